@@ -2,7 +2,15 @@
 -- DROP TABLES (to reset schema)
 -- ===============================
 DROP TABLE IF EXISTS ip_logs CASCADE;
+DROP TABLE IF EXISTS health_check_results CASCADE;
+DROP TABLE IF EXISTS health_checks CASCADE;
 DROP TABLE IF EXISTS otps CASCADE;
+DROP TABLE IF EXISTS tsig_keys CASCADE;
+DROP TABLE IF EXISTS zone_journal CASCADE;
+DROP TABLE IF EXISTS secondary_records CASCADE;
+DROP TABLE IF EXISTS zone_secondaries CASCADE;
+DROP TABLE IF EXISTS dnssec_rollovers CASCADE;
+DROP TABLE IF EXISTS dnssec_keys CASCADE;
 DROP TABLE IF EXISTS records CASCADE;
 DROP TABLE IF EXISTS domains CASCADE;
 DROP TABLE IF EXISTS users CASCADE;
@@ -29,6 +37,14 @@ CREATE TABLE domains (
     user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
     domain_name VARCHAR(255) UNIQUE NOT NULL,
     verified BOOLEAN DEFAULT FALSE,
+    dnssec BOOLEAN DEFAULT FALSE, -- serve signed responses
+    serial BIGINT NOT NULL DEFAULT 1, -- SOA serial, bumped on every record change
+    notified_serial BIGINT NOT NULL DEFAULT 0, -- last serial secondaries were notified of
+    serial_changed_at TIMESTAMP DEFAULT NOW(), -- when the serial was last bumped
+    primary_address VARCHAR(255), -- set for secondary zones transferred from this primary
+    primary_tsig_key VARCHAR(255), -- TSIG key for transfers from the primary
+    refresh_at TIMESTAMP, -- next SOA check of a secondary zone
+    expires_at TIMESTAMP, -- secondary zone data is no longer served after this
     created_at TIMESTAMP DEFAULT NOW(),
     updated_at TIMESTAMP DEFAULT NOW()
 );
@@ -37,15 +53,131 @@ CREATE TABLE domains (
 CREATE TABLE records (
     id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
     domain_id UUID NOT NULL REFERENCES domains(id) ON DELETE CASCADE,
-    type VARCHAR(10) NOT NULL CHECK (type IN ('A','AAAA','CNAME','MX','TXT','NS','SRV','CAA')),
+    type VARCHAR(10) NOT NULL CHECK (type IN ('A','AAAA','CNAME','MX','TXT','NS','SRV','CAA','SOA')),
     name VARCHAR(255) NOT NULL, -- subdomain (e.g., "www", "@")
     value TEXT NOT NULL,
     ttl INT DEFAULT 3600,
     priority INT,
     parent_record_id UUID REFERENCES records(id) ON DELETE SET NULL,
+    view VARCHAR(63), -- split-horizon view the record is served to, NULL for every client
+    continent CHAR(2), -- GeoIP continent code the record is served to, e.g. EU
+    country CHAR(2), -- ISO 3166 country code the record is served to, e.g. DE
+    weight INT CHECK (weight >= 0), -- share of answers among the values of its RRset, NULL for rotation
+    backup BOOLEAN DEFAULT FALSE, -- only served while no other value of its RRset is healthy
     created_at TIMESTAMP DEFAULT NOW(),
     updated_at TIMESTAMP DEFAULT NOW(),
-    CONSTRAINT unique_record UNIQUE (domain_id, type, name, value)
+    CONSTRAINT unique_record UNIQUE NULLS NOT DISTINCT (domain_id, type, name, value, view, continent, country, backup)
+);
+
+-- DNSSEC KEYS TABLE (per-zone signing keys)
+CREATE TABLE dnssec_keys (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    domain_id UUID NOT NULL REFERENCES domains(id) ON DELETE CASCADE,
+    key_type VARCHAR(3) NOT NULL CHECK (key_type IN ('KSK','ZSK')),
+    flags INT NOT NULL,
+    algorithm INT NOT NULL, -- 13 = ECDSAP256SHA256, 15 = ED25519
+    key_tag INT NOT NULL,
+    public_key TEXT NOT NULL,
+    private_key TEXT NOT NULL,
+    -- published: in the DNSKEY RRset only; active: also signs;
+    -- retired: a ZSK no longer signing or a KSK being replaced, still published;
+    -- removed: gone from the zone
+    state VARCHAR(10) NOT NULL DEFAULT 'active' CHECK (state IN ('published','active','retired','removed')),
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+-- DNSSEC ROLLOVERS TABLE (key rollovers in progress or done)
+CREATE TABLE dnssec_rollovers (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    domain_id UUID NOT NULL REFERENCES domains(id) ON DELETE CASCADE,
+    key_type VARCHAR(3) NOT NULL CHECK (key_type IN ('KSK','ZSK')),
+    old_key_id UUID NOT NULL REFERENCES dnssec_keys(id) ON DELETE CASCADE,
+    new_key_id UUID NOT NULL REFERENCES dnssec_keys(id) ON DELETE CASCADE,
+    status VARCHAR(12) NOT NULL CHECK (status IN ('publishing','retiring','waiting_ds','removing','completed')),
+    next_action_at TIMESTAMP, -- when the scheduler moves to the next step
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+-- ZONE SECONDARIES TABLE (servers allowed to transfer a zone)
+CREATE TABLE zone_secondaries (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    domain_id UUID NOT NULL REFERENCES domains(id) ON DELETE CASCADE,
+    address VARCHAR(45) NOT NULL, -- IPv4 or IPv6 address
+    tsig_key_name VARCHAR(255), -- optional TSIG key required for transfers
+    created_at TIMESTAMP DEFAULT NOW(),
+    CONSTRAINT unique_secondary UNIQUE (domain_id, address)
+);
+
+-- SECONDARY RECORDS TABLE (zone data transferred from a primary)
+CREATE TABLE secondary_records (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    domain_id UUID NOT NULL REFERENCES domains(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL, -- owner relative to the zone, "@" for the apex
+    type VARCHAR(10) NOT NULL,
+    ttl INT NOT NULL,
+    rdata TEXT NOT NULL -- record data in zone file format
+);
+
+-- ZONE JOURNAL TABLE (record changes per zone serial, for IXFR)
+CREATE TABLE zone_journal (
+    id BIGSERIAL PRIMARY KEY,
+    domain_id UUID NOT NULL REFERENCES domains(id) ON DELETE CASCADE,
+    serial BIGINT NOT NULL, -- zone serial the change produced
+    action VARCHAR(6) NOT NULL CHECK (action IN ('add','delete','serial','reset')), -- serial marks a new version, reset one IXFR cannot describe
+    type VARCHAR(10) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    value TEXT NOT NULL,
+    ttl INT NOT NULL,
+    priority INT,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+-- TSIG KEYS TABLE (shared secrets authenticating messages for a domain)
+CREATE TABLE tsig_keys (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    domain_id UUID NOT NULL REFERENCES domains(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(255) UNIQUE NOT NULL, -- key name as sent in the TSIG record
+    algorithm VARCHAR(32) NOT NULL CHECK (algorithm IN ('hmac-sha256','hmac-sha512')),
+    secret TEXT NOT NULL, -- base64
+    can_transfer BOOLEAN DEFAULT FALSE, -- may AXFR/IXFR the domain
+    can_update BOOLEAN DEFAULT FALSE, -- may send dynamic updates to the domain
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+-- HEALTH CHECKS TABLE (probes deciding whether a record is served)
+CREATE TABLE health_checks (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    record_id UUID UNIQUE NOT NULL REFERENCES records(id) ON DELETE CASCADE,
+    type VARCHAR(8) NOT NULL CHECK (type IN ('tcp','http','https')),
+    port INT NOT NULL CHECK (port BETWEEN 1 AND 65535),
+    path TEXT NOT NULL DEFAULT '/', -- http(s) only
+    host VARCHAR(255), -- Host header and TLS server name, http(s) only
+    expected_status INT NOT NULL DEFAULT 200, -- http(s) only
+    expected_body TEXT, -- text the http(s) response body must contain
+    interval_seconds INT NOT NULL DEFAULT 30 CHECK (interval_seconds >= 5),
+    timeout_seconds INT NOT NULL DEFAULT 5 CHECK (timeout_seconds >= 1),
+    rise INT NOT NULL DEFAULT 2 CHECK (rise >= 1), -- successes in a row that make the target healthy
+    fall INT NOT NULL DEFAULT 3 CHECK (fall >= 1), -- failures in a row that make the target unhealthy
+    healthy BOOLEAN NOT NULL DEFAULT TRUE,
+    last_checked_at TIMESTAMP,
+    last_error TEXT,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+-- HEALTH CHECK RESULTS TABLE (recent outcomes of each health check)
+CREATE TABLE health_check_results (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    check_id UUID NOT NULL REFERENCES health_checks(id) ON DELETE CASCADE,
+    success BOOLEAN NOT NULL,
+    healthy BOOLEAN NOT NULL, -- state of the target after this result
+    error TEXT,
+    duration_ms INT NOT NULL,
+    checked_at TIMESTAMP DEFAULT NOW()
 );
 
 -- IP LOGS TABLE
@@ -77,9 +209,62 @@ CREATE INDEX idx_domains_name ON domains(domain_name);
 -- Fast lookup for DNS records
 CREATE INDEX idx_records_lookup ON records(domain_id, name, type);
 
+-- Fast lookup for a zone's signing keys
+CREATE INDEX idx_dnssec_keys_domain ON dnssec_keys(domain_id);
+CREATE INDEX idx_dnssec_rollovers_due ON dnssec_rollovers(next_action_at) WHERE status <> 'completed';
+
+-- Listing a domain's TSIG keys
+CREATE INDEX idx_tsig_keys_domain ON tsig_keys(domain_id);
+
+-- Loading a secondary zone and finding those due for a refresh
+CREATE INDEX idx_secondary_records_domain ON secondary_records(domain_id);
+CREATE INDEX idx_domains_refresh ON domains(refresh_at) WHERE primary_address IS NOT NULL;
+
+-- Walking a zone's journal for IXFR
+CREATE INDEX idx_zone_journal_serial ON zone_journal(domain_id, serial);
+-- Pruning expired journal entries
+CREATE INDEX idx_zone_journal_created ON zone_journal(created_at);
+
+-- Health check history, newest first
+CREATE INDEX idx_health_check_results_check ON health_check_results(check_id, checked_at);
+
 -- Fast lookup by user activity
 CREATE INDEX idx_ip_logs_user ON ip_logs(user_id);
 CREATE INDEX idx_ip_logs_ip ON ip_logs(ip);
 
 -- Fast lookups for users by email and id
 CREATE INDEX idx_users_email_id ON users(email, id);
+
+-- ===============================
+-- ZONE CHANGE NOTIFICATIONS
+-- ===============================
+
+-- The DNS server keeps zones in memory and reloads one when its domain ID is
+-- announced on the zone_changes channel. TG_ARGV[0] names the column holding
+-- the domain ID; repeated announcements within a transaction are merged.
+CREATE OR REPLACE FUNCTION notify_zone_change() RETURNS trigger AS $$
+DECLARE
+    changed JSONB;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        changed := to_jsonb(OLD);
+    ELSE
+        changed := to_jsonb(NEW);
+    END IF;
+    PERFORM pg_notify('zone_changes', changed->>TG_ARGV[0]);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER domains_zone_change
+    AFTER INSERT OR DELETE OR UPDATE OF domain_name, verified, dnssec, serial, primary_address, expires_at ON domains
+    FOR EACH ROW EXECUTE FUNCTION notify_zone_change('id');
+CREATE TRIGGER records_zone_change
+    AFTER INSERT OR UPDATE OR DELETE ON records
+    FOR EACH ROW EXECUTE FUNCTION notify_zone_change('domain_id');
+CREATE TRIGGER dnssec_keys_zone_change
+    AFTER INSERT OR UPDATE OR DELETE ON dnssec_keys
+    FOR EACH ROW EXECUTE FUNCTION notify_zone_change('domain_id');
+CREATE TRIGGER secondary_records_zone_change
+    AFTER INSERT OR UPDATE OR DELETE ON secondary_records
+    FOR EACH ROW EXECUTE FUNCTION notify_zone_change('domain_id');
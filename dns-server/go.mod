@@ -9,6 +9,7 @@ require (
 	github.com/joho/godotenv v1.5.1
 	github.com/julienschmidt/httprouter v1.3.0
 	github.com/miekg/dns v1.1.68
+	github.com/oschwald/maxminddb-golang v1.13.1
 )
 
 require (
@@ -0,0 +1,401 @@
+package controllers
+
+import (
+	"dns-server/internal/models"
+	"dns-server/internal/services"
+	"dns-server/internal/utils"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+// ====================
+// ENABLE DNSSEC
+// POST /domains/:id/dnssec
+// ====================
+func (c *Controllers) EnableDNSSEC(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	domain, ok := c.ownedDomain(w, r, ps.ByName("id"))
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Algorithm string `json:"algorithm"`
+	}
+	if r.ContentLength > 0 {
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			utils.Error(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+	if input.Algorithm == "" {
+		input.Algorithm = "ECDSAP256SHA256"
+	}
+	if _, ok := services.DNSSECAlgorithms[input.Algorithm]; !ok {
+		utils.Error(w, http.StatusBadRequest, "Unsupported algorithm; use ECDSAP256SHA256 or ED25519")
+		return
+	}
+
+	if domain.DNSSEC {
+		utils.Error(w, http.StatusConflict, "DNSSEC is already enabled for this domain")
+		return
+	}
+	if domain.Primary != nil {
+		utils.Error(w, http.StatusConflict, "A secondary zone is signed by its primary")
+		return
+	}
+
+	keys, err := c.DB.GetDNSSECKeysByDomain(domain.ID.String())
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to fetch DNSSEC keys")
+		return
+	}
+
+	// Re-enabling reuses the existing keys so the DS at the parent stays valid
+	if len(services.ParentKeys(keys, domain.DomainName)) == 0 {
+		for _, keyType := range []string{"KSK", "ZSK"} {
+			key, err := services.GenerateDNSSECKey(domain.ID, domain.DomainName, keyType, input.Algorithm, services.KeyActive)
+			if err != nil {
+				utils.Error(w, http.StatusInternalServerError, "Failed to generate DNSSEC key")
+				return
+			}
+			if err := c.DB.CreateDNSSECKey(key); err != nil {
+				utils.Error(w, http.StatusInternalServerError, "Failed to store DNSSEC key")
+				return
+			}
+			keys = append(keys, *key)
+		}
+	}
+
+	if err := c.DB.SetDomainDNSSEC(domain.ID.String(), true); err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to enable DNSSEC")
+		return
+	}
+	domain.DNSSEC = true
+
+	utils.Created(w, "DNSSEC enabled; publish the DS records at your registrar", dnssecStatus(domain, keys, nil))
+}
+
+// ====================
+// DISABLE DNSSEC
+// DELETE /domains/:id/dnssec
+// ====================
+func (c *Controllers) DisableDNSSEC(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	domain, ok := c.ownedDomain(w, r, ps.ByName("id"))
+	if !ok {
+		return
+	}
+
+	if !domain.DNSSEC {
+		utils.Error(w, http.StatusConflict, "DNSSEC is not enabled for this domain")
+		return
+	}
+
+	// Keys are kept so signing can be turned back on with the same DS
+	if err := c.DB.SetDomainDNSSEC(domain.ID.String(), false); err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to disable DNSSEC")
+		return
+	}
+
+	utils.Success(w, "DNSSEC disabled; remove the DS records at your registrar first or the domain will fail validation", nil)
+}
+
+// ====================
+// GET DNSSEC STATUS
+// GET /domains/:id/dnssec
+// ====================
+func (c *Controllers) GetDNSSECStatus(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	domain, ok := c.ownedDomain(w, r, ps.ByName("id"))
+	if !ok {
+		return
+	}
+
+	keys, err := c.DB.GetDNSSECKeysByDomain(domain.ID.String())
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to fetch DNSSEC keys")
+		return
+	}
+	rollovers, err := c.DB.GetDNSSECRolloversByDomain(domain.ID.String())
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to fetch DNSSEC rollovers")
+		return
+	}
+
+	var pending []models.DNSSECRollover
+	for _, rollover := range rollovers {
+		if rollover.Status != "completed" {
+			pending = append(pending, rollover)
+		}
+	}
+
+	utils.Success(w, "DNSSEC status", dnssecStatus(domain, keys, pending))
+}
+
+// ====================
+// LIST DNSSEC KEYS
+// GET /domains/:id/dnssec/keys
+// ====================
+func (c *Controllers) GetDNSSECKeys(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	domain, ok := c.ownedDomain(w, r, ps.ByName("id"))
+	if !ok {
+		return
+	}
+
+	keys, err := c.DB.GetDNSSECKeysByDomain(domain.ID.String())
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to fetch DNSSEC keys")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if len(keys) == 0 {
+		json.NewEncoder(w).Encode([]interface{}{})
+		return
+	}
+	json.NewEncoder(w).Encode(keys)
+}
+
+// ====================
+// GET DS RECORDS
+// GET /domains/:id/dnssec/ds
+// ====================
+func (c *Controllers) GetDNSSECDS(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	domain, ok := c.ownedDomain(w, r, ps.ByName("id"))
+	if !ok {
+		return
+	}
+
+	keys, err := c.DB.GetDNSSECKeysByDomain(domain.ID.String())
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to fetch DNSSEC keys")
+		return
+	}
+
+	ds := services.DSRecords(keys, domain.DomainName)
+	if len(ds) == 0 {
+		utils.Error(w, http.StatusNotFound, "DNSSEC is not enabled for this domain")
+		return
+	}
+
+	utils.Success(w, "DS records", map[string]interface{}{
+		"ds":      ds,
+		"cds":     services.CDSRecords(keys, domain.DomainName),
+		"cdnskey": services.CDNSKEYRecords(keys, domain.DomainName),
+	})
+}
+
+// ====================
+// LIST DNSSEC ROLLOVERS
+// GET /domains/:id/dnssec/rollovers
+// ====================
+func (c *Controllers) GetDNSSECRollovers(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	domain, ok := c.ownedDomain(w, r, ps.ByName("id"))
+	if !ok {
+		return
+	}
+
+	rollovers, err := c.DB.GetDNSSECRolloversByDomain(domain.ID.String())
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to fetch DNSSEC rollovers")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if len(rollovers) == 0 {
+		json.NewEncoder(w).Encode([]interface{}{})
+		return
+	}
+	json.NewEncoder(w).Encode(rollovers)
+}
+
+// ====================
+// START KEY ROLLOVER
+// POST /domains/:id/dnssec/rollovers
+// ====================
+func (c *Controllers) StartDNSSECRollover(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	domain, ok := c.ownedDomain(w, r, ps.ByName("id"))
+	if !ok {
+		return
+	}
+
+	var input struct {
+		KeyType string `json:"key_type"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		utils.Error(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if input.KeyType != "KSK" && input.KeyType != "ZSK" {
+		utils.Error(w, http.StatusBadRequest, "key_type must be KSK or ZSK")
+		return
+	}
+
+	if !domain.DNSSEC {
+		utils.Error(w, http.StatusConflict, "DNSSEC is not enabled for this domain")
+		return
+	}
+
+	rollovers, err := c.DB.GetDNSSECRolloversByDomain(domain.ID.String())
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to fetch DNSSEC rollovers")
+		return
+	}
+	for _, rollover := range rollovers {
+		if rollover.KeyType == input.KeyType && rollover.Status != "completed" {
+			utils.Error(w, http.StatusConflict, "A "+input.KeyType+" rollover is already in progress")
+			return
+		}
+	}
+
+	keys, err := c.DB.GetDNSSECKeysByDomain(domain.ID.String())
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to fetch DNSSEC keys")
+		return
+	}
+	var old *models.DNSSECKey
+	for i := range keys {
+		if keys[i].KeyType == input.KeyType && keys[i].State == services.KeyActive {
+			old = &keys[i]
+		}
+	}
+	if old == nil {
+		utils.Error(w, http.StatusConflict, "No active "+input.KeyType+" to roll over")
+		return
+	}
+
+	now := time.Now()
+	rollover := &models.DNSSECRollover{
+		DomainID:  domain.ID,
+		KeyType:   input.KeyType,
+		OldKeyID:  old.ID,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	// ZSK pre-publish: the new key is only published until resolvers have
+	// it cached. KSK double signature: the new key signs right away next to
+	// the old one, which drops out of the DS set.
+	state := services.KeyPublished
+	if input.KeyType == "KSK" {
+		state = services.KeyActive
+		rollover.Status = "waiting_ds"
+	} else {
+		next := now.Add(services.DNSSECPublishWait)
+		rollover.Status = "publishing"
+		rollover.NextActionAt = &next
+	}
+
+	key, err := services.GenerateDNSSECKey(domain.ID, domain.DomainName, input.KeyType, services.AlgorithmName(old.Algorithm), state)
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to generate DNSSEC key")
+		return
+	}
+	if err := c.DB.CreateDNSSECKey(key); err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to store DNSSEC key")
+		return
+	}
+	rollover.NewKeyID = key.ID
+
+	if input.KeyType == "KSK" {
+		if err := c.DB.UpdateDNSSECKeyState(old.ID.String(), services.KeyRetired); err != nil {
+			utils.Error(w, http.StatusInternalServerError, "Failed to update DNSSEC key")
+			return
+		}
+	}
+
+	if err := c.DB.CreateDNSSECRollover(rollover); err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to start rollover")
+		return
+	}
+
+	data := map[string]interface{}{"rollover": rollover, "key": key}
+	if input.KeyType == "KSK" {
+		data["ds"] = services.DSRecords([]models.DNSSECKey{*key}, domain.DomainName)
+		utils.Created(w, "KSK rollover started; replace the DS records at your registrar, then confirm", data)
+		return
+	}
+	utils.Created(w, "ZSK rollover started", data)
+}
+
+// ====================
+// CONFIRM NEW DS PUBLISHED
+// POST /domains/:id/dnssec/rollovers/:rollover/ds-published
+// ====================
+func (c *Controllers) ConfirmDNSSECDS(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	domain, ok := c.ownedDomain(w, r, ps.ByName("id"))
+	if !ok {
+		return
+	}
+
+	rollover, err := c.DB.GetDNSSECRolloverByID(ps.ByName("rollover"))
+	if err != nil || rollover == nil || rollover.DomainID != domain.ID {
+		utils.Error(w, http.StatusNotFound, "Rollover not found")
+		return
+	}
+	if rollover.Status != "waiting_ds" {
+		utils.Error(w, http.StatusConflict, "Rollover is not waiting for a DS update")
+		return
+	}
+
+	// The old KSK keeps signing until the old DS has expired from caches
+	next := time.Now().Add(services.DNSSECDSWait)
+	rollover.Status = "removing"
+	rollover.NextActionAt = &next
+	rollover.UpdatedAt = time.Now()
+	if err := c.DB.UpdateDNSSECRollover(rollover); err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to update rollover")
+		return
+	}
+
+	utils.Success(w, "DS update confirmed; the old KSK will be removed", rollover)
+}
+
+// dnssecStatus builds the DNSSEC overview returned by the API.
+func dnssecStatus(domain *models.Domain, keys []models.DNSSECKey, rollovers []models.DNSSECRollover) map[string]interface{} {
+	var current []models.DNSSECKey
+	for _, key := range keys {
+		if key.State != services.KeyRemoved {
+			current = append(current, key)
+		}
+	}
+
+	return map[string]interface{}{
+		"enabled":   domain.DNSSEC,
+		"keys":      current,
+		"ds":        services.DSRecords(keys, domain.DomainName),
+		"cds":       services.CDSRecords(keys, domain.DomainName),
+		"cdnskey":   services.CDNSKEYRecords(keys, domain.DomainName),
+		"rollovers": rollovers,
+	}
+}
+
+// ownedDomain loads the domain with the given ID and checks that it belongs
+// to the current user, writing the error response and returning false
+// otherwise.
+func (c *Controllers) ownedDomain(w http.ResponseWriter, r *http.Request, domainID string) (*models.Domain, bool) {
+	if domainID == "" {
+		utils.Error(w, http.StatusBadRequest, "Domain ID is required")
+		return nil, false
+	}
+
+	domain, err := c.DB.GetDomainByID(domainID)
+	if err != nil || domain == nil {
+		utils.Error(w, http.StatusNotFound, "Domain not found")
+		return nil, false
+	}
+
+	userID := utils.GetUserID(r)
+	if userID == uuid.Nil {
+		utils.Error(w, http.StatusUnauthorized, "Unauthorized")
+		return nil, false
+	}
+	if domain.UserID != userID {
+		utils.Error(w, http.StatusForbidden, "Forbidden")
+		return nil, false
+	}
+
+	return domain, true
+}
@@ -2,10 +2,14 @@ package controllers
 
 import (
 	"dns-server/internal/models"
+	"dns-server/internal/services"
 	"dns-server/internal/utils"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -15,12 +19,18 @@ import (
 func (c *Controllers) RegisterDomain(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	var input struct {
 		DomainName string `json:"domain_name"`
+		// Primary makes the domain a secondary zone transferred from this
+		// server, "host" or "host:port"
+		Primary        string `json:"primary"`
+		PrimaryTSIGKey string `json:"primary_tsig_key"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
 		utils.Error(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
+	// Stored names are compared against query names by the DNS server
+	input.DomainName = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(input.DomainName), "."))
 	if input.DomainName == "" {
 		utils.Error(w, http.StatusBadRequest, "Domain name is required")
 		return
@@ -32,6 +42,19 @@ func (c *Controllers) RegisterDomain(w http.ResponseWriter, r *http.Request, _ h
 		return
 	}
 
+	// A name under someone else's domain would take over part of their zone
+	parents, err := c.DB.GetDomainsByNames(parentDomains(input.DomainName))
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to register domain")
+		return
+	}
+	for _, parent := range parents {
+		if parent.UserID != userID {
+			utils.Error(w, http.StatusForbidden, "Domain is part of a domain registered by another user")
+			return
+		}
+	}
+
 	d := &models.Domain{
 		DomainName: input.DomainName,
 		UserID:     userID,
@@ -39,6 +62,21 @@ func (c *Controllers) RegisterDomain(w http.ResponseWriter, r *http.Request, _ h
 		UpdatedAt:  time.Now(),
 		Verified:   false,
 	}
+	if primary := strings.TrimSpace(input.Primary); primary != "" {
+		if !validPrimary(primary) {
+			utils.Error(w, http.StatusBadRequest, "primary must be a public host or host:port")
+			return
+		}
+		d.Primary = &primary
+		if key := strings.TrimSpace(input.PrimaryTSIGKey); key != "" {
+			key = strings.ToLower(strings.TrimSuffix(key, "."))
+			if !c.usableTSIGKey(key, uuid.Nil) {
+				utils.Error(w, http.StatusBadRequest, "primary_tsig_key must be a server-wide TSIG key")
+				return
+			}
+			d.PrimaryTSIGKey = &key
+		}
+	}
 
 	domainId, err := c.DB.CreateDomain(d)
 	if err != nil {
@@ -50,10 +88,51 @@ func (c *Controllers) RegisterDomain(w http.ResponseWriter, r *http.Request, _ h
 		"id":          domainId,
 		"domain_name": d.DomainName,
 		"user_id":     d.UserID,
+		"primary":     d.Primary,
 		"created_at":  d.CreatedAt,
 	})
 }
 
+// parentDomains returns the names a domain is part of, "example.com" and
+// "com" for "www.example.com".
+func parentDomains(name string) []string {
+	var parents []string
+	for i := strings.IndexByte(name, '.'); i >= 0; i = strings.IndexByte(name, '.') {
+		name = name[i+1:]
+		parents = append(parents, name)
+	}
+	return parents
+}
+
+// validPrimary checks that a primary server is given as a host name or IP
+// address with an optional port, and that it is a public host: refreshes
+// are made on the owner's behalf and must not reach this host or the
+// networks behind it.
+func validPrimary(primary string) bool {
+	host, port, err := net.SplitHostPort(primary)
+	if err != nil {
+		host, port = primary, "53"
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return false
+	}
+	if host == "" || strings.ContainsAny(host, "/ ") {
+		return false
+	}
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		if ips, err = net.LookupIP(host); err != nil || len(ips) == 0 {
+			return false
+		}
+	}
+	for _, ip := range ips {
+		if !services.IsPublicIP(ip) {
+			return false
+		}
+	}
+	return true
+}
+
 func (c *Controllers) GetUserDomains(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	userID := utils.GetUserID(r)
 	if userID == uuid.Nil {
@@ -160,7 +239,12 @@ func (c *Controllers) GetDNSRecordsByDomain(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	records, err := c.DB.GetRecordsByDomain(domainID)
+	var records []models.Record
+	if domain.Primary != nil {
+		records, err = c.DB.GetSecondaryRecords(domainID)
+	} else {
+		records, err = c.DB.GetRecordsByDomain(domainID)
+	}
 	if err != nil {
 		http.Error(w, "Failed to fetch records", http.StatusInternalServerError)
 		return
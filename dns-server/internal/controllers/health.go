@@ -0,0 +1,231 @@
+package controllers
+
+import (
+	"database/sql"
+	"dns-server/internal/models"
+	"dns-server/internal/utils"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Defaults of new health checks, and how much of their history is returned.
+const (
+	defaultHealthInterval = 30
+	defaultHealthTimeout  = 5
+	defaultHealthRise     = 2
+	defaultHealthFall     = 3
+	defaultHealthHistory  = 100
+	maxHealthHistory      = 1000
+)
+
+// ====================
+// GET HEALTH CHECK STATE AND HISTORY
+// GET /records/:id/health?limit=100
+// ====================
+func (c *Controllers) GetRecordHealth(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	record, ok := c.ownedRecord(w, r, ps.ByName("id"))
+	if !ok {
+		return
+	}
+
+	check, err := c.DB.GetHealthCheckByRecord(record.ID.String())
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.Error(w, http.StatusNotFound, "Record has no health check")
+		return
+	}
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to fetch health check")
+		return
+	}
+
+	limit := defaultHealthHistory
+	if v, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && v > 0 {
+		limit = min(v, maxHealthHistory)
+	}
+	history, err := c.DB.GetHealthCheckResults(check.ID.String(), limit)
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to fetch health check history")
+		return
+	}
+	if history == nil {
+		history = []models.HealthCheckResult{}
+	}
+
+	utils.Success(w, "Health check fetched successfully", map[string]interface{}{
+		"check":   check,
+		"history": history,
+	})
+}
+
+// ====================
+// CREATE OR REPLACE HEALTH CHECK
+// PUT /records/:id/health
+// ====================
+func (c *Controllers) PutRecordHealth(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	record, ok := c.ownedRecord(w, r, ps.ByName("id"))
+	if !ok {
+		return
+	}
+	if record.Type != "A" && record.Type != "AAAA" && record.Type != "CNAME" {
+		utils.Error(w, http.StatusBadRequest, "Health checks are only supported on A, AAAA and CNAME records")
+		return
+	}
+
+	var input struct {
+		Type           string  `json:"type"`
+		Port           int     `json:"port"`
+		Path           string  `json:"path"`
+		Host           *string `json:"host"`
+		ExpectedStatus int     `json:"expected_status"`
+		ExpectedBody   *string `json:"expected_body"`
+		Interval       int     `json:"interval"`
+		Timeout        int     `json:"timeout"`
+		Rise           int     `json:"rise"`
+		Fall           int     `json:"fall"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		utils.Error(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	input.Type = strings.ToLower(input.Type)
+	switch input.Type {
+	case "tcp":
+		if input.Port == 0 {
+			utils.Error(w, http.StatusBadRequest, "A tcp health check needs a port")
+			return
+		}
+	case "http":
+		if input.Port == 0 {
+			input.Port = 80
+		}
+	case "https":
+		if input.Port == 0 {
+			input.Port = 443
+		}
+	default:
+		utils.Error(w, http.StatusBadRequest, "type must be tcp, http or https")
+		return
+	}
+	if input.Port < 1 || input.Port > 65535 {
+		utils.Error(w, http.StatusBadRequest, "port must be between 1 and 65535")
+		return
+	}
+	if input.Path == "" {
+		input.Path = "/"
+	}
+	if !strings.HasPrefix(input.Path, "/") {
+		utils.Error(w, http.StatusBadRequest, "path must start with /")
+		return
+	}
+	if input.ExpectedStatus == 0 {
+		input.ExpectedStatus = http.StatusOK
+	}
+	if input.Interval == 0 {
+		input.Interval = defaultHealthInterval
+	}
+	if input.Timeout == 0 {
+		input.Timeout = min(defaultHealthTimeout, input.Interval)
+	}
+	if input.Rise == 0 {
+		input.Rise = defaultHealthRise
+	}
+	if input.Fall == 0 {
+		input.Fall = defaultHealthFall
+	}
+	if input.Interval < 5 || input.Timeout < 1 || input.Timeout > input.Interval {
+		utils.Error(w, http.StatusBadRequest, "interval must be at least 5 seconds and timeout between 1 second and the interval")
+		return
+	}
+	if input.Rise < 1 || input.Fall < 1 {
+		utils.Error(w, http.StatusBadRequest, "rise and fall must be at least 1")
+		return
+	}
+
+	check, err := c.DB.GetHealthCheckByRecord(record.ID.String())
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		utils.Error(w, http.StatusInternalServerError, "Failed to fetch health check")
+		return
+	}
+	created := check == nil
+	if created {
+		// Targets are trusted until their check says otherwise
+		check = &models.HealthCheck{RecordID: record.ID, Target: record.Value, Healthy: true, CreatedAt: time.Now()}
+	}
+	check.Type = input.Type
+	check.Port = input.Port
+	check.Path = input.Path
+	check.Host = input.Host
+	check.ExpectedStatus = input.ExpectedStatus
+	check.ExpectedBody = input.ExpectedBody
+	check.Interval = input.Interval
+	check.Timeout = input.Timeout
+	check.Rise = input.Rise
+	check.Fall = input.Fall
+	check.UpdatedAt = time.Now()
+
+	if created {
+		err = c.DB.CreateHealthCheck(check)
+	} else {
+		err = c.DB.UpdateHealthCheck(check)
+	}
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to save health check")
+		return
+	}
+
+	if created {
+		utils.Created(w, "Health check created successfully", check)
+		return
+	}
+	utils.Success(w, "Health check updated successfully", check)
+}
+
+// ====================
+// DELETE HEALTH CHECK
+// DELETE /records/:id/health
+// ====================
+func (c *Controllers) DeleteRecordHealth(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	record, ok := c.ownedRecord(w, r, ps.ByName("id"))
+	if !ok {
+		return
+	}
+
+	check, err := c.DB.GetHealthCheckByRecord(record.ID.String())
+	if err != nil {
+		utils.Error(w, http.StatusNotFound, "Record has no health check")
+		return
+	}
+	if err := c.DB.DeleteHealthCheck(check.ID.String()); err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to delete health check")
+		return
+	}
+
+	utils.Success(w, "Health check deleted successfully", nil)
+}
+
+// ownedRecord loads the record with the given ID and checks that it
+// belongs to one of the current user's domains, writing the error response
+// and returning false otherwise.
+func (c *Controllers) ownedRecord(w http.ResponseWriter, r *http.Request, recordID string) (*models.Record, bool) {
+	if recordID == "" {
+		utils.Error(w, http.StatusBadRequest, "Record ID is required")
+		return nil, false
+	}
+
+	record, err := c.DB.GetRecordByID(recordID)
+	if err != nil || record == nil {
+		utils.Error(w, http.StatusNotFound, "Record not found")
+		return nil, false
+	}
+	if _, ok := c.ownedDomain(w, r, record.DomainID.String()); !ok {
+		return nil, false
+	}
+	return record, true
+}
@@ -3,10 +3,12 @@ package controllers
 import (
 	"crypto/rand"
 	"dns-server/internal/models"
+	"dns-server/internal/services"
 	"encoding/json"
 	"fmt"
 	"log"
 	"math/big"
+	"net"
 	"net/http"
 	"os"
 	"strings"
@@ -55,7 +57,7 @@ func generateOTP() string {
 }
 
 func (c *Controllers) sendOTPMail(r *http.Request, email, userName, otp string) {
-	// Extract user IP and approximate location (you might use a GeoIP service here)
+	// Extract user IP and approximate location
 	currentTime := time.Now().Format("3:04 PM MST")
 
 	ip := r.Header.Get("X-Forwarded-For")
@@ -107,29 +109,14 @@ func loadAndPopulateTemplate(filePath string, data map[string]string) ([]byte, e
 	return []byte(htmlContent), nil
 }
 
+// getLocationFromIP describes where ip is from the local GeoIP database.
 func getLocationFromIP(ip string) string {
-	url := fmt.Sprintf("http://ip-api.com/json/%s", ip)
-	resp, err := http.Get(url)
-	if err != nil {
-		log.Printf("Failed to get location for IP %s: %v", ip, err)
+	loc, ok := services.LookupLocation(net.ParseIP(strings.TrimSpace(strings.Split(ip, ",")[0])))
+	switch {
+	case !ok || loc.CountryName == "":
 		return "Unknown Location"
+	case loc.City == "":
+		return loc.CountryName
 	}
-	defer resp.Body.Close()
-
-	var geoData struct {
-		Status  string `json:"status"`
-		Country string `json:"country"`
-		City    string `json:"city"`
-	}
-
-	if err := json.NewDecoder(resp.Body).Decode(&geoData); err != nil {
-		log.Printf("Failed to parse geo data for IP %s: %v", ip, err)
-		return "India"
-	}
-
-	if geoData.Status == "success" && geoData.City != "" && geoData.Country != "" {
-		return fmt.Sprintf("%s, %s", geoData.City, geoData.Country)
-	}
-
-	return "India"
+	return fmt.Sprintf("%s, %s", loc.City, loc.CountryName)
 }
@@ -2,10 +2,13 @@ package controllers
 
 import (
 	"dns-server/internal/models"
+	"dns-server/internal/services"
 	"dns-server/internal/utils"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/google/uuid"
 	"github.com/julienschmidt/httprouter"
@@ -13,12 +16,17 @@ import (
 
 func (c *Controllers) RegisterDNSRecord(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	var input struct {
-		DomainId   string `json:"domain_id"`
-		Type       string `json:"type"`
-		Name       string `json:"name"`
-		Value      string `json:"value"`
-		TTL        int    `json:"ttl"`
-		Priority   *int   `json:"priority"`
+		DomainId   string  `json:"domain_id"`
+		Type       string  `json:"type"`
+		Name       string  `json:"name"`
+		Value      string  `json:"value"`
+		TTL        int     `json:"ttl"`
+		Priority   *int    `json:"priority"`
+		Weight     *int    `json:"weight"`
+		Backup     bool    `json:"backup"`
+		View       *string `json:"view"`
+		Continent  *string `json:"continent"`
+		Country    *string `json:"country"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
@@ -39,14 +47,45 @@ func (c *Controllers) RegisterDNSRecord(w http.ResponseWriter, r *http.Request,
 		return
 	}
 
+	if domain.Primary != nil {
+		http.Error(w, "Records of a secondary zone are managed by its primary", http.StatusForbidden)
+		return
+	}
+
 	// check if domain is verified
 	if !domain.Verified {
 		http.Error(w, "Domain not verified", http.StatusForbidden)
 		return
 	}
 
-	// check if record already exists
-	existingRecord, _ := c.DB.GetRecordByDetails(input.DomainId, input.Type, input.Name)
+	if input.View != nil && !services.IsDNSView(*input.View) {
+		http.Error(w, "Unknown view", http.StatusBadRequest)
+		return
+	}
+
+	continent, country, err := geoTarget(input.Continent, input.Country)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if input.Weight != nil && *input.Weight < 0 {
+		http.Error(w, "Weight must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	if input.Type == "SOA" && input.Name != "@" {
+		http.Error(w, "An SOA record can only be set at the apex, @", http.StatusBadRequest)
+		return
+	}
+
+	// check if record already exists. A name holds a single CNAME or SOA,
+	// but any number of values of other types.
+	value := &input.Value
+	if input.Type == "CNAME" || input.Type == "SOA" {
+		value = nil
+	}
+	existingRecord, _ := c.DB.GetRecordByDetails(input.DomainId, input.Type, input.Name, value, input.View, continent, country, input.Backup)
 
 	if existingRecord != nil {
 		http.Error(w, "Record already exists", http.StatusConflict)
@@ -54,12 +93,17 @@ func (c *Controllers) RegisterDNSRecord(w http.ResponseWriter, r *http.Request,
 	}
 
 	record := &models.Record{
-		DomainID: domain.ID,
-		Type:     input.Type,
-		Name:     input.Name,
-		Value:    input.Value,
-		TTL:      input.TTL,
-		Priority: input.Priority,
+		DomainID:  domain.ID,
+		Type:      input.Type,
+		Name:      input.Name,
+		Value:     input.Value,
+		TTL:       input.TTL,
+		Priority:  input.Priority,
+		Weight:    input.Weight,
+		Backup:    input.Backup,
+		View:      input.View,
+		Continent: continent,
+		Country:   country,
 	}
 
 	if err := c.DB.CreateRecord(record); err != nil {
@@ -106,17 +150,31 @@ func (c *Controllers) UpdateDNSRecord(w http.ResponseWriter, r *http.Request, ps
 		return
 	}
 	var input struct {
-		Type     *string `json:"type"`
-		Name     *string `json:"name"`
-		Value    *string `json:"value"`
-		TTL      *int    `json:"ttl"`
-		Priority *int    `json:"priority"`
+		Type      *string `json:"type"`
+		Name      *string `json:"name"`
+		Value     *string `json:"value"`
+		TTL       *int    `json:"ttl"`
+		Priority  *int    `json:"priority"`
+		// Weight sets the record's share of answers; a negative weight
+		// removes it, so the RRset is rotated instead
+		Weight    *int    `json:"weight"`
+		Backup    *bool   `json:"backup"`
+		// View moves the record into a view; "" moves it out of every view
+		View      *string `json:"view"`
+		// Continent and Country change the geographic target; "" for both
+		// serves the record everywhere
+		Continent *string `json:"continent"`
+		Country   *string `json:"country"`
 	}
 	
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
+	if input.View != nil && *input.View != "" && !services.IsDNSView(*input.View) {
+		http.Error(w, "Unknown view", http.StatusBadRequest)
+		return
+	}
 	record, err := c.DB.GetRecordByID(recordID)
 	fmt.Println(record)
 	if err != nil || record == nil {
@@ -134,6 +192,10 @@ func (c *Controllers) UpdateDNSRecord(w http.ResponseWriter, r *http.Request, ps
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
+	if domain.Primary != nil {
+		http.Error(w, "Records of a secondary zone are managed by its primary", http.StatusForbidden)
+		return
+	}
 	
 	// update fields
 	if input.Type != nil {
@@ -151,6 +213,28 @@ func (c *Controllers) UpdateDNSRecord(w http.ResponseWriter, r *http.Request, ps
 	if input.Priority != nil {
 		record.Priority = input.Priority
 	}
+	if input.Weight != nil {
+		record.Weight = input.Weight
+		if *input.Weight < 0 {
+			record.Weight = nil
+		}
+	}
+	if input.Backup != nil {
+		record.Backup = *input.Backup
+	}
+	if input.View != nil {
+		record.View = input.View
+		if *input.View == "" {
+			record.View = nil
+		}
+	}
+	if input.Continent != nil || input.Country != nil {
+		record.Continent, record.Country, err = geoTarget(input.Continent, input.Country)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
 
 	if err := c.DB.UpdateRecord(record); err != nil {
 		fmt.Println(err)
@@ -184,6 +268,10 @@ func (c *Controllers) DeleteDNSRecord(w http.ResponseWriter, r *http.Request, ps
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
+	if domain.Primary != nil {
+		http.Error(w, "Records of a secondary zone are managed by its primary", http.StatusForbidden)
+		return
+	}
 	
 	if err := c.DB.DeleteRecord(recordID); err != nil {
 		http.Error(w, "Failed to delete record", http.StatusInternalServerError)
@@ -192,3 +280,32 @@ func (c *Controllers) DeleteDNSRecord(w http.ResponseWriter, r *http.Request, ps
 	
 	utils.Success(w, "Record deleted successfully", nil)
 }
+
+// geoTarget validates the geographic target of a record: a continent code
+// such as "EU" or a country code such as "DE", but not both. Empty codes
+// are no target.
+func geoTarget(continent, country *string) (*string, *string, error) {
+	normalize := func(code *string) *string {
+		if code == nil || strings.TrimSpace(*code) == "" {
+			return nil
+		}
+		v := strings.ToUpper(strings.TrimSpace(*code))
+		return &v
+	}
+	continent, country = normalize(continent), normalize(country)
+
+	switch {
+	case continent != nil && country != nil:
+		return nil, nil, errors.New("A record targets either a continent or a country")
+	case continent != nil && !services.IsContinentCode(*continent):
+		return nil, nil, fmt.Errorf("Unknown continent code %q", *continent)
+	case country != nil && !isCountryCode(*country):
+		return nil, nil, fmt.Errorf("Invalid country code %q", *country)
+	}
+	return continent, country, nil
+}
+
+// isCountryCode reports whether code looks like an ISO 3166 alpha-2 code.
+func isCountryCode(code string) bool {
+	return len(code) == 2 && code[0] >= 'A' && code[0] <= 'Z' && code[1] >= 'A' && code[1] <= 'Z'
+}
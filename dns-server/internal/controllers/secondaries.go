@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"dns-server/internal/models"
+	"dns-server/internal/utils"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// ====================
+// LIST SECONDARIES
+// GET /domains/:id/secondaries
+// ====================
+func (c *Controllers) GetSecondaries(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	domain, ok := c.ownedDomain(w, r, ps.ByName("id"))
+	if !ok {
+		return
+	}
+
+	secondaries, err := c.DB.GetSecondariesByDomain(domain.ID.String())
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to fetch secondaries")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if len(secondaries) == 0 {
+		json.NewEncoder(w).Encode([]interface{}{})
+		return
+	}
+	json.NewEncoder(w).Encode(secondaries)
+}
+
+// ====================
+// ADD SECONDARY
+// POST /domains/:id/secondaries
+// ====================
+func (c *Controllers) AddSecondary(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	domain, ok := c.ownedDomain(w, r, ps.ByName("id"))
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Address     string `json:"address"`
+		TSIGKeyName string `json:"tsig_key_name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		utils.Error(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	ip := net.ParseIP(strings.TrimSpace(input.Address))
+	if ip == nil {
+		utils.Error(w, http.StatusBadRequest, "address must be an IPv4 or IPv6 address")
+		return
+	}
+
+	secondary := &models.Secondary{
+		DomainID:  domain.ID,
+		Address:   ip.String(),
+		CreatedAt: time.Now(),
+	}
+	if name := strings.TrimSpace(input.TSIGKeyName); name != "" {
+		name = strings.ToLower(strings.TrimSuffix(name, "."))
+		if !c.usableTSIGKey(name, domain.ID) {
+			utils.Error(w, http.StatusBadRequest, "tsig_key_name must be a TSIG key of this domain")
+			return
+		}
+		secondary.TSIGKeyName = &name
+	}
+
+	existing, err := c.DB.GetSecondariesByDomain(domain.ID.String())
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to fetch secondaries")
+		return
+	}
+	for _, s := range existing {
+		if s.Address == secondary.Address {
+			utils.Error(w, http.StatusConflict, "This secondary is already allowed to transfer the zone")
+			return
+		}
+	}
+
+	if err := c.DB.CreateSecondary(secondary); err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to add secondary")
+		return
+	}
+
+	utils.Created(w, "Secondary added successfully", secondary)
+}
+
+// ====================
+// REMOVE SECONDARY
+// DELETE /domains/:id/secondaries/:secondary
+// ====================
+func (c *Controllers) DeleteSecondary(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	domain, ok := c.ownedDomain(w, r, ps.ByName("id"))
+	if !ok {
+		return
+	}
+
+	secondary, err := c.DB.GetSecondaryByID(ps.ByName("secondary"))
+	if err != nil || secondary.DomainID != domain.ID {
+		utils.Error(w, http.StatusNotFound, "Secondary not found")
+		return
+	}
+
+	if err := c.DB.DeleteSecondary(secondary.ID.String()); err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to remove secondary")
+		return
+	}
+
+	utils.Success(w, "Secondary removed successfully", nil)
+}
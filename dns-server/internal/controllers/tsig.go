@@ -0,0 +1,215 @@
+package controllers
+
+import (
+	"dns-server/internal/models"
+	"dns-server/internal/services"
+	"dns-server/internal/utils"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/miekg/dns"
+)
+
+// ====================
+// LIST TSIG KEYS
+// GET /domains/:id/tsig-keys
+// ====================
+func (c *Controllers) GetTSIGKeys(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	domain, ok := c.ownedDomain(w, r, ps.ByName("id"))
+	if !ok {
+		return
+	}
+
+	keys, err := c.DB.GetTSIGKeysByDomain(domain.ID.String())
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to fetch TSIG keys")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if len(keys) == 0 {
+		json.NewEncoder(w).Encode([]interface{}{})
+		return
+	}
+	json.NewEncoder(w).Encode(keys)
+}
+
+// ====================
+// CREATE TSIG KEY
+// POST /domains/:id/tsig-keys
+// ====================
+func (c *Controllers) CreateTSIGKey(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	domain, ok := c.ownedDomain(w, r, ps.ByName("id"))
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Name        string `json:"name"`
+		Algorithm   string `json:"algorithm"`
+		CanTransfer bool   `json:"can_transfer"`
+		CanUpdate   bool   `json:"can_update"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		utils.Error(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	// Key names are sent as domain names in the TSIG record
+	name := strings.ToLower(strings.TrimSuffix(strings.TrimSpace(input.Name), "."))
+	if _, ok := dns.IsDomainName(name); name == "" || !ok {
+		utils.Error(w, http.StatusBadRequest, "name must be a valid domain name, e.g. acme-update."+domain.DomainName)
+		return
+	}
+	if input.Algorithm == "" {
+		input.Algorithm = "hmac-sha256"
+	}
+	input.Algorithm = strings.ToLower(input.Algorithm)
+	if _, ok := services.TSIGAlgorithms[input.Algorithm]; !ok {
+		utils.Error(w, http.StatusBadRequest, "Unsupported algorithm; use hmac-sha256 or hmac-sha512")
+		return
+	}
+	if !input.CanTransfer && !input.CanUpdate {
+		utils.Error(w, http.StatusBadRequest, "A key needs can_transfer, can_update or both")
+		return
+	}
+
+	if existing, _ := c.DB.GetTSIGKeyByName(name); existing != nil || services.IsServerTSIGKey(name) {
+		utils.Error(w, http.StatusConflict, "A TSIG key with this name already exists")
+		return
+	}
+
+	secret, err := services.GenerateTSIGSecret(input.Algorithm)
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to generate TSIG secret")
+		return
+	}
+
+	key := &models.TSIGKey{
+		DomainID:    domain.ID,
+		UserID:      domain.UserID,
+		Name:        name,
+		Algorithm:   input.Algorithm,
+		Secret:      secret,
+		CanTransfer: input.CanTransfer,
+		CanUpdate:   input.CanUpdate,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+	if err := c.DB.CreateTSIGKey(key); err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to create TSIG key")
+		return
+	}
+
+	// The secret is only ever shown here
+	utils.Created(w, "TSIG key created; store the secret now, it cannot be retrieved later", map[string]interface{}{
+		"key":    key,
+		"secret": secret,
+	})
+}
+
+// ====================
+// GET TSIG KEY
+// GET /domains/:id/tsig-keys/:key
+// ====================
+func (c *Controllers) GetTSIGKey(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	key, ok := c.ownedTSIGKey(w, r, ps)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(key)
+}
+
+// ====================
+// UPDATE TSIG KEY PERMISSIONS
+// PUT /domains/:id/tsig-keys/:key
+// ====================
+func (c *Controllers) UpdateTSIGKey(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	key, ok := c.ownedTSIGKey(w, r, ps)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		CanTransfer *bool `json:"can_transfer"`
+		CanUpdate   *bool `json:"can_update"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		utils.Error(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if input.CanTransfer != nil {
+		key.CanTransfer = *input.CanTransfer
+	}
+	if input.CanUpdate != nil {
+		key.CanUpdate = *input.CanUpdate
+	}
+	if !key.CanTransfer && !key.CanUpdate {
+		utils.Error(w, http.StatusBadRequest, "A key needs can_transfer, can_update or both; delete it instead")
+		return
+	}
+	key.UpdatedAt = time.Now()
+
+	if err := c.DB.UpdateTSIGKey(key); err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to update TSIG key")
+		return
+	}
+
+	utils.Success(w, "TSIG key updated successfully", key)
+}
+
+// ====================
+// DELETE TSIG KEY
+// DELETE /domains/:id/tsig-keys/:key
+// ====================
+func (c *Controllers) DeleteTSIGKey(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	key, ok := c.ownedTSIGKey(w, r, ps)
+	if !ok {
+		return
+	}
+
+	if err := c.DB.DeleteTSIGKey(key.ID.String()); err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to delete TSIG key")
+		return
+	}
+
+	utils.Success(w, "TSIG key deleted successfully", nil)
+}
+
+// ownedTSIGKey loads the TSIG key named by the :key parameter and checks
+// that it belongs to the current user's domain given by :id, writing the
+// error response and returning false otherwise.
+func (c *Controllers) ownedTSIGKey(w http.ResponseWriter, r *http.Request, ps httprouter.Params) (*models.TSIGKey, bool) {
+	domain, ok := c.ownedDomain(w, r, ps.ByName("id"))
+	if !ok {
+		return nil, false
+	}
+
+	key, err := c.DB.GetTSIGKeyByID(ps.ByName("key"))
+	if err != nil || key.DomainID != domain.ID {
+		utils.Error(w, http.StatusNotFound, "TSIG key not found")
+		return nil, false
+	}
+	return key, true
+}
+
+// usableTSIGKey reports whether a zone may name the TSIG key called name:
+// one of its own keys, or a server-wide key. domainID is uuid.Nil for a zone
+// not registered yet, which can only use server-wide keys.
+func (c *Controllers) usableTSIGKey(name string, domainID uuid.UUID) bool {
+	if services.IsServerTSIGKey(name) {
+		return true
+	}
+	if domainID == uuid.Nil {
+		return false
+	}
+	key, err := c.DB.GetTSIGKeyByName(name)
+	return err == nil && key.DomainID == domainID
+}
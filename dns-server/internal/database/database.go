@@ -1,11 +1,13 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"dns-server/internal/models"
 	"fmt"
 	"log"
 	"os"
+	"time"
 
 	"github.com/google/uuid"
 	_ "github.com/jackc/pgx/v5/stdlib"
@@ -27,18 +29,73 @@ type Service interface {
 	CreateDomain(domain *models.Domain) (uuid.UUID, error)
 	GetDomainByID(id string) (*models.Domain, error)
 	GetDomainsByUser(userID string) ([]models.Domain, error)
+	GetVerifiedDomains() ([]models.Domain, error)
+	GetLongestMatchingDomain(names []string) (*models.Domain, error)
+	GetDomainsByNames(names []string) ([]models.Domain, error)
+	SetDomainDNSSEC(id string, enabled bool) error
+	GetDomainsPendingNotify() ([]models.Domain, error)
+	BumpSignedZoneSerials(before time.Time) (int, error)
+	SetDomainNotifiedSerial(id string, serial int64) error
 	UpdateDomain(domain *models.Domain) error
 	DeleteDomain(id string) error
 
 	// Records
 	CreateRecord(record *models.Record) error
 	GetRecordByID(id string) (*models.Record, error)
-	GetRecordByDetails(domainID string, recordType string, name string) (*models.Record, error)
+	GetRecordByDetails(domainID string, recordType string, name string, value, view, continent, country *string, backup bool) (*models.Record, error)
 	GetRecordsByDomain(domainID string) ([]models.Record, error)
 	UpdateRecord(record *models.Record) error
 	DeleteRecord(id string) error
 	GetRecordsByName(domain string, subdomain string) ([]models.Record, error)
+	UpdateZoneRecords(domainID string, plan func(records []models.Record) ([]models.RecordChange, error)) error
 	
+	// DNSSEC keys
+	CreateDNSSECKey(key *models.DNSSECKey) error
+	GetDNSSECKeysByDomain(domainID string) ([]models.DNSSECKey, error)
+	UpdateDNSSECKeyState(id string, state string) error
+	CreateDNSSECRollover(rollover *models.DNSSECRollover) error
+	GetDNSSECRolloverByID(id string) (*models.DNSSECRollover, error)
+	GetDNSSECRolloversByDomain(domainID string) ([]models.DNSSECRollover, error)
+	GetDueDNSSECRollovers(now time.Time) ([]models.DNSSECRollover, error)
+	UpdateDNSSECRollover(rollover *models.DNSSECRollover) error
+
+	// Secondary zones
+	GetSecondaryRecords(domainID string) ([]models.Record, error)
+	ReplaceSecondaryRecords(domainID string, serial int64, records []models.Record) error
+	GetDueSecondaryZones(now time.Time) ([]models.Domain, error)
+	SetSecondaryZoneTimers(domainID string, refreshAt time.Time, expiresAt *time.Time) error
+
+	// Zone journal
+	GetZoneChangesSince(domainID string, serial int64) ([]models.ZoneChange, error)
+	DeleteZoneChangesBefore(before time.Time) (int64, error)
+
+	// Zone change notifications
+	ListenZoneChanges(ctx context.Context, changed func(domainID string)) error
+
+	// TSIG keys
+	CreateTSIGKey(key *models.TSIGKey) error
+	GetTSIGKeyByID(id string) (*models.TSIGKey, error)
+	GetTSIGKeyByName(name string) (*models.TSIGKey, error)
+	GetTSIGKeysByDomain(domainID string) ([]models.TSIGKey, error)
+	UpdateTSIGKey(key *models.TSIGKey) error
+	DeleteTSIGKey(id string) error
+
+	// Health checks
+	CreateHealthCheck(check *models.HealthCheck) error
+	GetHealthCheckByRecord(recordID string) (*models.HealthCheck, error)
+	GetHealthChecks() ([]models.HealthCheck, error)
+	UpdateHealthCheck(check *models.HealthCheck) error
+	DeleteHealthCheck(id string) error
+	AddHealthCheckResult(result *models.HealthCheckResult, lastError *string) error
+	GetHealthCheckResults(checkID string, limit int) ([]models.HealthCheckResult, error)
+	DeleteHealthCheckResultsBefore(t time.Time) error
+
+	// Zone secondaries
+	CreateSecondary(secondary *models.Secondary) error
+	GetSecondaryByID(id string) (*models.Secondary, error)
+	GetSecondariesByDomain(domainID string) ([]models.Secondary, error)
+	DeleteSecondary(id string) error
+
 	// IP Logs
 	CreateIPLog(log *models.IPLog) error
 	GetIPLogsByUser(userID string) ([]models.IPLog, error)
@@ -67,8 +124,7 @@ func New() Service {
 	if dbInstance != nil {
 		return dbInstance
 	}
-	connStr := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable&search_path=%s", username, password, host, port, database, schema)
-	db, err := sql.Open("pgx", connStr)
+	db, err := sql.Open("pgx", connString())
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -78,6 +134,10 @@ func New() Service {
 	return dbInstance
 }
 
+func connString() string {
+	return fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable&search_path=%s", username, password, host, port, database, schema)
+}
+
 func (s *service) Close() error {
 	log.Printf("Disconnected from database: %s", database)
 	return s.db.Close()
@@ -0,0 +1,161 @@
+package database
+
+import (
+	"dns-server/internal/models"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CreateDNSSECKey stores a new key of a domain and bumps the domain's
+// serial, as the key changes its DNSKEY RRset.
+func (s *service) CreateDNSSECKey(key *models.DNSSECKey) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO dnssec_keys (domain_id, key_type, flags, algorithm, key_tag, public_key, private_key, state, created_at, updated_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10)
+		RETURNING id
+	`
+	err = tx.QueryRow(query,
+		key.DomainID,
+		key.KeyType,
+		key.Flags,
+		key.Algorithm,
+		key.KeyTag,
+		key.PublicKey,
+		key.PrivateKey,
+		key.State,
+		key.CreatedAt,
+		key.UpdatedAt,
+	).Scan(&key.ID)
+	if err != nil {
+		return err
+	}
+	if _, err := resetSerial(tx, key.DomainID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *service) GetDNSSECKeysByDomain(domainID string) ([]models.DNSSECKey, error) {
+	query := `
+		SELECT id, domain_id, key_type, flags, algorithm, key_tag, public_key, private_key, state, created_at, updated_at
+		FROM dnssec_keys
+		WHERE domain_id=$1
+		ORDER BY created_at`
+	rows, err := s.db.Query(query, domainID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []models.DNSSECKey
+	for rows.Next() {
+		var key models.DNSSECKey
+		err := rows.Scan(&key.ID, &key.DomainID, &key.KeyType, &key.Flags, &key.Algorithm, &key.KeyTag, &key.PublicKey, &key.PrivateKey, &key.State, &key.CreatedAt, &key.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// UpdateDNSSECKeyState moves a key to state and bumps the serial of its
+// domain, as the state decides whether the key is published and signs.
+func (s *service) UpdateDNSSECKeyState(id string, state string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var domainID uuid.UUID
+	err = tx.QueryRow(`UPDATE dnssec_keys SET state=$1, updated_at=$2 WHERE id=$3 RETURNING domain_id`, state, time.Now(), id).Scan(&domainID)
+	if err != nil {
+		return err
+	}
+	if _, err := resetSerial(tx, domainID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *service) CreateDNSSECRollover(rollover *models.DNSSECRollover) error {
+	query := `
+		INSERT INTO dnssec_rollovers (domain_id, key_type, old_key_id, new_key_id, status, next_action_at, created_at, updated_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8)
+		RETURNING id
+	`
+	return s.db.QueryRow(query,
+		rollover.DomainID,
+		rollover.KeyType,
+		rollover.OldKeyID,
+		rollover.NewKeyID,
+		rollover.Status,
+		rollover.NextActionAt,
+		rollover.CreatedAt,
+		rollover.UpdatedAt,
+	).Scan(&rollover.ID)
+}
+
+func (s *service) GetDNSSECRolloverByID(id string) (*models.DNSSECRollover, error) {
+	query := `SELECT id, domain_id, key_type, old_key_id, new_key_id, status, next_action_at, created_at, updated_at FROM dnssec_rollovers WHERE id=$1`
+	row := s.db.QueryRow(query, id)
+	var rollover models.DNSSECRollover
+	err := row.Scan(&rollover.ID, &rollover.DomainID, &rollover.KeyType, &rollover.OldKeyID, &rollover.NewKeyID, &rollover.Status, &rollover.NextActionAt, &rollover.CreatedAt, &rollover.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &rollover, nil
+}
+
+func (s *service) GetDNSSECRolloversByDomain(domainID string) ([]models.DNSSECRollover, error) {
+	query := `
+		SELECT id, domain_id, key_type, old_key_id, new_key_id, status, next_action_at, created_at, updated_at
+		FROM dnssec_rollovers
+		WHERE domain_id=$1
+		ORDER BY created_at DESC`
+	return s.queryDNSSECRollovers(query, domainID)
+}
+
+// GetDueDNSSECRollovers returns the unfinished rollovers whose next step is
+// scheduled at or before now.
+func (s *service) GetDueDNSSECRollovers(now time.Time) ([]models.DNSSECRollover, error) {
+	query := `
+		SELECT id, domain_id, key_type, old_key_id, new_key_id, status, next_action_at, created_at, updated_at
+		FROM dnssec_rollovers
+		WHERE status <> 'completed' AND next_action_at <= $1
+		ORDER BY next_action_at`
+	return s.queryDNSSECRollovers(query, now)
+}
+
+func (s *service) UpdateDNSSECRollover(rollover *models.DNSSECRollover) error {
+	query := `UPDATE dnssec_rollovers SET status=$1, next_action_at=$2, updated_at=$3 WHERE id=$4`
+	_, err := s.db.Exec(query, rollover.Status, rollover.NextActionAt, rollover.UpdatedAt, rollover.ID)
+	return err
+}
+
+func (s *service) queryDNSSECRollovers(query string, args ...interface{}) ([]models.DNSSECRollover, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rollovers []models.DNSSECRollover
+	for rows.Next() {
+		var rollover models.DNSSECRollover
+		err := rows.Scan(&rollover.ID, &rollover.DomainID, &rollover.KeyType, &rollover.OldKeyID, &rollover.NewKeyID, &rollover.Status, &rollover.NextActionAt, &rollover.CreatedAt, &rollover.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		rollovers = append(rollovers, rollover)
+	}
+	return rollovers, nil
+}
@@ -2,38 +2,57 @@ package database
 
 import (
 	"dns-server/internal/models"
+	"time"
 
 	"github.com/google/uuid"
 )
 
 func (s *service) CreateDomain(domain *models.Domain) (uuid.UUID, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer tx.Rollback()
+
 	query := `
-		INSERT INTO domains (user_id, domain_name, verified, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5)
-		RETURNING id
+		INSERT INTO domains (user_id, domain_name, verified, serial, primary_address, primary_tsig_key, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, serial
 	`
 
 	var id uuid.UUID
-	err := s.db.QueryRow(query,
+	var serial int64
+	err = tx.QueryRow(query,
 		domain.UserID,
 		domain.DomainName,
 		domain.Verified,
+		max(serialFloor(time.Now()), 1),
+		domain.Primary,
+		domain.PrimaryTSIGKey,
 		domain.CreatedAt,
 		domain.UpdatedAt,
-	).Scan(&id)
+	).Scan(&id, &serial)
 
 	if err != nil {
 		return uuid.Nil, err
 	}
 
+	// Journal the first version, which IXFR starts from
+	if err := journalMarker(tx, id, serial, "serial"); err != nil {
+		return uuid.Nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return uuid.Nil, err
+	}
 	return id, nil
 }
 
 func (s *service) GetDomainByID(id string) (*models.Domain, error) {
-	query := `SELECT id, user_id, domain_name, verified, created_at, updated_at FROM domains WHERE id=$1`
+	query := `SELECT id, user_id, domain_name, verified, dnssec, serial, primary_address, primary_tsig_key, refresh_at, expires_at, created_at, updated_at FROM domains WHERE id=$1`
 	row := s.db.QueryRow(query, id)
 	var domain models.Domain
-	err := row.Scan(&domain.ID, &domain.UserID, &domain.DomainName, &domain.Verified, &domain.CreatedAt, &domain.UpdatedAt)
+	err := row.Scan(&domain.ID, &domain.UserID, &domain.DomainName, &domain.Verified, &domain.DNSSEC, &domain.Serial, &domain.Primary, &domain.PrimaryTSIGKey, &domain.RefreshAt, &domain.ExpiresAt, &domain.CreatedAt, &domain.UpdatedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -41,7 +60,7 @@ func (s *service) GetDomainByID(id string) (*models.Domain, error) {
 }
 
 func (s *service) GetDomainsByUser(userID string) ([]models.Domain, error) {
-	query := `SELECT id, user_id, domain_name, verified, created_at, updated_at FROM domains WHERE user_id=$1`
+	query := `SELECT id, user_id, domain_name, verified, dnssec, serial, primary_address, primary_tsig_key, refresh_at, expires_at, created_at, updated_at FROM domains WHERE user_id=$1`
 	rows, err := s.db.Query(query, userID)
 	if err != nil {
 		return nil, err
@@ -51,7 +70,29 @@ func (s *service) GetDomainsByUser(userID string) ([]models.Domain, error) {
 	var domains []models.Domain
 	for rows.Next() {
 		var domain models.Domain
-		err := rows.Scan(&domain.ID, &domain.UserID, &domain.DomainName, &domain.Verified, &domain.CreatedAt, &domain.UpdatedAt)
+		err := rows.Scan(&domain.ID, &domain.UserID, &domain.DomainName, &domain.Verified, &domain.DNSSEC, &domain.Serial, &domain.Primary, &domain.PrimaryTSIGKey, &domain.RefreshAt, &domain.ExpiresAt, &domain.CreatedAt, &domain.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		domains = append(domains, domain)
+	}
+	return domains, nil
+}
+
+// GetVerifiedDomains returns every domain whose owner proved control of it,
+// which are the ones served.
+func (s *service) GetVerifiedDomains() ([]models.Domain, error) {
+	query := `SELECT id, user_id, domain_name, verified, dnssec, serial, primary_address, primary_tsig_key, refresh_at, expires_at, created_at, updated_at FROM domains WHERE verified`
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var domains []models.Domain
+	for rows.Next() {
+		var domain models.Domain
+		err := rows.Scan(&domain.ID, &domain.UserID, &domain.DomainName, &domain.Verified, &domain.DNSSEC, &domain.Serial, &domain.Primary, &domain.PrimaryTSIGKey, &domain.RefreshAt, &domain.ExpiresAt, &domain.CreatedAt, &domain.UpdatedAt)
 		if err != nil {
 			return nil, err
 		}
@@ -70,3 +111,132 @@ func (s *service) DeleteDomain(id string) error {
 	_, err := s.db.Exec(`DELETE FROM domains WHERE id=$1`, id)
 	return err
 }
+
+// GetLongestMatchingDomain returns the verified domain whose name is the
+// longest of the given candidates, or sql.ErrNoRows if none of them is
+// registered and verified.
+func (s *service) GetLongestMatchingDomain(names []string) (*models.Domain, error) {
+	query := `
+		SELECT id, user_id, domain_name, verified, dnssec, serial, primary_address, primary_tsig_key, refresh_at, expires_at, created_at, updated_at
+		FROM domains
+		WHERE domain_name = ANY($1) AND verified
+		ORDER BY length(domain_name) DESC
+		LIMIT 1`
+	row := s.db.QueryRow(query, names)
+	var domain models.Domain
+	err := row.Scan(&domain.ID, &domain.UserID, &domain.DomainName, &domain.Verified, &domain.DNSSEC, &domain.Serial, &domain.Primary, &domain.PrimaryTSIGKey, &domain.RefreshAt, &domain.ExpiresAt, &domain.CreatedAt, &domain.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &domain, nil
+}
+
+// GetDomainsByNames returns the domains, verified or not, registered under
+// any of the given names.
+func (s *service) GetDomainsByNames(names []string) ([]models.Domain, error) {
+	query := `SELECT id, user_id, domain_name, verified, dnssec, serial, primary_address, primary_tsig_key, refresh_at, expires_at, created_at, updated_at FROM domains WHERE domain_name = ANY($1)`
+	rows, err := s.db.Query(query, names)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var domains []models.Domain
+	for rows.Next() {
+		var domain models.Domain
+		err := rows.Scan(&domain.ID, &domain.UserID, &domain.DomainName, &domain.Verified, &domain.DNSSEC, &domain.Serial, &domain.Primary, &domain.PrimaryTSIGKey, &domain.RefreshAt, &domain.ExpiresAt, &domain.CreatedAt, &domain.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		domains = append(domains, domain)
+	}
+	return domains, rows.Err()
+}
+
+// SetDomainDNSSEC turns signing of a domain on or off, bumping its serial so
+// secondaries pick up the zone signed or unsigned.
+func (s *service) SetDomainDNSSEC(id string, enabled bool) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var domainID uuid.UUID
+	err = tx.QueryRow(`UPDATE domains SET dnssec=$1, updated_at=$2 WHERE id=$3 RETURNING id`, enabled, time.Now(), id).Scan(&domainID)
+	if err != nil {
+		return err
+	}
+	if _, err := resetSerial(tx, domainID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// BumpSignedZoneSerials bumps the serial of every signed zone with
+// secondaries whose serial has not changed since before, so they transfer
+// the zone with fresh signatures. It returns how many zones were bumped.
+func (s *service) BumpSignedZoneSerials(before time.Time) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT id FROM domains d
+		WHERE dnssec AND verified AND primary_address IS NULL AND serial_changed_at < $1
+			AND EXISTS (SELECT 1 FROM zone_secondaries z WHERE z.domain_id = d.id)
+		FOR UPDATE`
+	rows, err := tx.Query(query, before)
+	if err != nil {
+		return 0, err
+	}
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, id := range ids {
+		if _, err := resetSerial(tx, id); err != nil {
+			return 0, err
+		}
+	}
+	return len(ids), tx.Commit()
+}
+
+// GetDomainsPendingNotify returns the verified domains whose serial changed
+// since their secondaries were last notified.
+func (s *service) GetDomainsPendingNotify() ([]models.Domain, error) {
+	query := `SELECT id, user_id, domain_name, verified, dnssec, serial, primary_address, primary_tsig_key, refresh_at, expires_at, created_at, updated_at FROM domains WHERE serial <> notified_serial AND verified`
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var domains []models.Domain
+	for rows.Next() {
+		var domain models.Domain
+		err := rows.Scan(&domain.ID, &domain.UserID, &domain.DomainName, &domain.Verified, &domain.DNSSEC, &domain.Serial, &domain.Primary, &domain.PrimaryTSIGKey, &domain.RefreshAt, &domain.ExpiresAt, &domain.CreatedAt, &domain.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		domains = append(domains, domain)
+	}
+	return domains, nil
+}
+
+func (s *service) SetDomainNotifiedSerial(id string, serial int64) error {
+	_, err := s.db.Exec(`UPDATE domains SET notified_serial=$1 WHERE id=$2`, serial, id)
+	return err
+}
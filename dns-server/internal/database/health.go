@@ -0,0 +1,165 @@
+package database
+
+import (
+	"dns-server/internal/models"
+	"time"
+)
+
+func (s *service) CreateHealthCheck(check *models.HealthCheck) error {
+	query := `
+		INSERT INTO health_checks (record_id, type, port, path, host, expected_status, expected_body, interval_seconds, timeout_seconds, rise, fall, healthy, created_at, updated_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14)
+		RETURNING id
+	`
+	return s.db.QueryRow(query,
+		check.RecordID,
+		check.Type,
+		check.Port,
+		check.Path,
+		check.Host,
+		check.ExpectedStatus,
+		check.ExpectedBody,
+		check.Interval,
+		check.Timeout,
+		check.Rise,
+		check.Fall,
+		check.Healthy,
+		check.CreatedAt,
+		check.UpdatedAt,
+	).Scan(&check.ID)
+}
+
+func (s *service) GetHealthCheckByRecord(recordID string) (*models.HealthCheck, error) {
+	query := `
+		SELECT h.id, h.record_id, r.value, h.type, h.port, h.path, h.host, h.expected_status, h.expected_body, h.interval_seconds, h.timeout_seconds, h.rise, h.fall, h.healthy, h.last_checked_at, h.last_error, h.created_at, h.updated_at
+		FROM health_checks h
+		JOIN records r ON r.id = h.record_id
+		WHERE h.record_id=$1`
+	row := s.db.QueryRow(query, recordID)
+	var check models.HealthCheck
+	err := row.Scan(&check.ID, &check.RecordID, &check.Target, &check.Type, &check.Port, &check.Path, &check.Host, &check.ExpectedStatus, &check.ExpectedBody, &check.Interval, &check.Timeout, &check.Rise, &check.Fall, &check.Healthy, &check.LastCheckedAt, &check.LastError, &check.CreatedAt, &check.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &check, nil
+}
+
+// GetHealthChecks returns every health check, for the checker to run.
+func (s *service) GetHealthChecks() ([]models.HealthCheck, error) {
+	query := `
+		SELECT h.id, h.record_id, r.value, h.type, h.port, h.path, h.host, h.expected_status, h.expected_body, h.interval_seconds, h.timeout_seconds, h.rise, h.fall, h.healthy, h.last_checked_at, h.last_error, h.created_at, h.updated_at
+		FROM health_checks h
+		JOIN records r ON r.id = h.record_id`
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var checks []models.HealthCheck
+	for rows.Next() {
+		var check models.HealthCheck
+		err := rows.Scan(&check.ID, &check.RecordID, &check.Target, &check.Type, &check.Port, &check.Path, &check.Host, &check.ExpectedStatus, &check.ExpectedBody, &check.Interval, &check.Timeout, &check.Rise, &check.Fall, &check.Healthy, &check.LastCheckedAt, &check.LastError, &check.CreatedAt, &check.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, check)
+	}
+	return checks, rows.Err()
+}
+
+// UpdateHealthCheck stores the settings of check. Its state is only
+// changed by AddHealthCheckResult.
+func (s *service) UpdateHealthCheck(check *models.HealthCheck) error {
+	query := `
+		UPDATE health_checks
+		SET type=$1, port=$2, path=$3, host=$4, expected_status=$5, expected_body=$6, interval_seconds=$7, timeout_seconds=$8, rise=$9, fall=$10, updated_at=$11
+		WHERE id=$12`
+	_, err := s.db.Exec(query,
+		check.Type,
+		check.Port,
+		check.Path,
+		check.Host,
+		check.ExpectedStatus,
+		check.ExpectedBody,
+		check.Interval,
+		check.Timeout,
+		check.Rise,
+		check.Fall,
+		check.UpdatedAt,
+		check.ID,
+	)
+	return err
+}
+
+func (s *service) DeleteHealthCheck(id string) error {
+	_, err := s.db.Exec(`DELETE FROM health_checks WHERE id=$1`, id)
+	return err
+}
+
+// AddHealthCheckResult stores the outcome of a probe to the check's history
+// and its state, lastError being the last failure seen.
+func (s *service) AddHealthCheckResult(result *models.HealthCheckResult, lastError *string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO health_check_results (check_id, success, healthy, error, duration_ms, checked_at)
+		VALUES ($1,$2,$3,$4,$5,$6)
+		RETURNING id
+	`
+	err = tx.QueryRow(query,
+		result.CheckID,
+		result.Success,
+		result.Healthy,
+		result.Error,
+		result.Duration,
+		result.CheckedAt,
+	).Scan(&result.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE health_checks SET healthy=$1, last_checked_at=$2, last_error=$3 WHERE id=$4`,
+		result.Healthy, result.CheckedAt, lastError, result.CheckID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetHealthCheckResults returns the latest limit results of a check, newest
+// first.
+func (s *service) GetHealthCheckResults(checkID string, limit int) ([]models.HealthCheckResult, error) {
+	query := `
+		SELECT id, check_id, success, healthy, error, duration_ms, checked_at
+		FROM health_check_results
+		WHERE check_id=$1
+		ORDER BY checked_at DESC
+		LIMIT $2`
+	rows, err := s.db.Query(query, checkID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []models.HealthCheckResult
+	for rows.Next() {
+		var result models.HealthCheckResult
+		err := rows.Scan(&result.ID, &result.CheckID, &result.Success, &result.Healthy, &result.Error, &result.Duration, &result.CheckedAt)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+// DeleteHealthCheckResultsBefore drops the history older than t.
+func (s *service) DeleteHealthCheckResultsBefore(t time.Time) error {
+	_, err := s.db.Exec(`DELETE FROM health_check_results WHERE checked_at < $1`, t)
+	return err
+}
@@ -0,0 +1,133 @@
+package database
+
+import (
+	"database/sql"
+	"dns-server/internal/models"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// serialFloor returns the lowest serial a zone changed at now may have.
+// With DNS_SERIAL_SCHEME=date serials follow the YYYYMMDDnn convention, so
+// the first change of a day jumps to YYYYMMDD00. Otherwise serials simply
+// count changes and there is no floor.
+func serialFloor(now time.Time) int64 {
+	if os.Getenv("DNS_SERIAL_SCHEME") != "date" {
+		return 0
+	}
+	y, m, d := now.UTC().Date()
+	return int64(y*1000000 + int(m)*10000 + d*100)
+}
+
+// bumpSerial advances the SOA serial of a domain inside tx, wrapping at
+// 2^32 as RFC 1982 serial arithmetic does, and returns the new serial. The
+// new version is journaled, so the journal holds every serial the zone had
+// and IXFR can tell where it has gaps.
+func bumpSerial(tx *sql.Tx, domainID uuid.UUID) (int64, error) {
+	return newVersion(tx, domainID, "serial")
+}
+
+// resetSerial is bumpSerial for changes the journal cannot describe, such
+// as signing being turned on or off. IXFR from a serial before one is
+// answered with a full transfer.
+func resetSerial(tx *sql.Tx, domainID uuid.UUID) (int64, error) {
+	return newVersion(tx, domainID, "reset")
+}
+
+// newVersion bumps the serial and writes the journal marker of the new
+// version, action being "serial" or "reset".
+func newVersion(tx *sql.Tx, domainID uuid.UUID, action string) (int64, error) {
+	var serial int64
+	query := `UPDATE domains SET serial=GREATEST((serial + 1) % 4294967296, $1), serial_changed_at=NOW() WHERE id=$2 RETURNING serial`
+	if err := tx.QueryRow(query, serialFloor(time.Now()), domainID).Scan(&serial); err != nil {
+		return 0, err
+	}
+	if err := journalMarker(tx, domainID, serial, action); err != nil {
+		return 0, err
+	}
+	return serial, nil
+}
+
+// journalMarker writes the journal entry that records a zone reaching
+// serial. It carries no record; its type is SOA, which IXFR skips.
+func journalMarker(tx *sql.Tx, domainID uuid.UUID, serial int64, action string) error {
+	query := `
+		INSERT INTO zone_journal (domain_id, serial, action, type, name, value, ttl, created_at)
+		VALUES ($1,$2,$3,'SOA','@','',0,$4)
+	`
+	_, err := tx.Exec(query, domainID, serial, action, time.Now())
+	return err
+}
+
+// transferred reports whether record is part of the zone sent to
+// secondaries. Records of a view or a geographic target and backup records
+// are only served by this server.
+func transferred(record *models.Record) bool {
+	return record.View == nil && record.Continent == nil && record.Country == nil && !record.Backup
+}
+
+// journalRecord writes a zone journal entry for record inside tx. Records
+// that are not transferred are not journaled.
+func journalRecord(tx *sql.Tx, serial int64, action string, record *models.Record) error {
+	if !transferred(record) {
+		return nil
+	}
+	query := `
+		INSERT INTO zone_journal (domain_id, serial, action, type, name, value, ttl, priority, created_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)
+	`
+	_, err := tx.Exec(query,
+		record.DomainID,
+		serial,
+		action,
+		record.Type,
+		record.Name,
+		record.Value,
+		record.TTL,
+		record.Priority,
+		time.Now(),
+	)
+	return err
+}
+
+// GetZoneChangesSince returns the journal of a domain after the version
+// with the given serial, in the order the changes were made. It is empty
+// when that version is not journaled, so the changes returned always pick
+// up exactly where a client at serial left off, whatever the gaps between
+// serials.
+func (s *service) GetZoneChangesSince(domainID string, serial int64) ([]models.ZoneChange, error) {
+	query := `
+		SELECT id, domain_id, serial, action, type, name, value, ttl, priority, created_at
+		FROM zone_journal
+		WHERE domain_id=$1 AND id > (SELECT max(id) FROM zone_journal WHERE domain_id=$1 AND serial=$2)
+		ORDER BY id`
+	rows, err := s.db.Query(query, domainID, serial)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var changes []models.ZoneChange
+	for rows.Next() {
+		var change models.ZoneChange
+		err := rows.Scan(&change.ID, &change.DomainID, &change.Serial, &change.Action, &change.Type, &change.Name, &change.Value, &change.TTL, &change.Priority, &change.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+// DeleteZoneChangesBefore prunes journal entries written before the given
+// time and returns how many were removed. IXFR from a serial that is no
+// longer journaled is answered with a full transfer.
+func (s *service) DeleteZoneChangesBefore(before time.Time) (int64, error) {
+	result, err := s.db.Exec(`DELETE FROM zone_journal WHERE created_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
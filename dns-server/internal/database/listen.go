@@ -0,0 +1,37 @@
+package database
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// zoneChangesChannel is the channel the triggers in db.sql announce the ID
+// of a domain on whenever its zone data changes.
+const zoneChangesChannel = "zone_changes"
+
+// ListenZoneChanges calls changed with the ID of every domain whose
+// records, keys or settings change, until ctx is done or the connection
+// fails. Once listening has started changed is called with an empty ID, as
+// changes made before that were missed. Notifications use a connection of
+// their own, outside the pool.
+func (s *service) ListenZoneChanges(ctx context.Context, changed func(domainID string)) error {
+	conn, err := pgx.Connect(ctx, connString())
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+zoneChangesChannel); err != nil {
+		return err
+	}
+	changed("")
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		changed(notification.Payload)
+	}
+}
@@ -1,34 +1,68 @@
 package database
 
 import (
+	"database/sql"
 	"dns-server/internal/models"
 	"fmt"
+
+	"github.com/google/uuid"
 )
 
+// CreateRecord inserts record, bumps its zone's serial and journals the
+// addition in one transaction. Records of a view or of a geographic target
+// and backup records are never transferred, so they leave the serial and
+// journal alone, here and in UpdateRecord and DeleteRecord.
 func (s *service) CreateRecord(record *models.Record) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	query := `
-		INSERT INTO records (domain_id, type, name, value, ttl, priority, parent_record_id, created_at, updated_at)
-		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)
+		INSERT INTO records (domain_id, type, name, value, ttl, priority, weight, parent_record_id, view, continent, country, backup, created_at, updated_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14)
 		RETURNING id
 	`
-	return s.db.QueryRow(query,
+	err = tx.QueryRow(query,
 		record.DomainID,
 		record.Type,
 		record.Name,
 		record.Value,
 		record.TTL,
 		record.Priority,
+		record.Weight,
 		record.ParentRecordID,
+		record.View,
+		record.Continent,
+		record.Country,
+		record.Backup,
 		record.CreatedAt,
 		record.UpdatedAt,
 	).Scan(&record.ID)
+	if err != nil {
+		return err
+	}
+
+	if !transferred(record) {
+		return tx.Commit()
+	}
+
+	serial, err := bumpSerial(tx, record.DomainID)
+	if err != nil {
+		return err
+	}
+	if err := journalRecord(tx, serial, "add", record); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 func (s *service) GetRecordByID(id string) (*models.Record, error) {
-	query := `SELECT id, domain_id, type, name, value, ttl, priority, parent_record_id, created_at, updated_at FROM records WHERE id=$1`
+	query := `SELECT id, domain_id, type, name, value, ttl, priority, weight, parent_record_id, view, continent, country, backup, created_at, updated_at FROM records WHERE id=$1`
 	row := s.db.QueryRow(query, id)
 	var record models.Record
-	err := row.Scan(&record.ID, &record.DomainID, &record.Type, &record.Name, &record.Value, &record.TTL, &record.Priority, &record.ParentRecordID, &record.CreatedAt, &record.UpdatedAt)
+	err := row.Scan(&record.ID, &record.DomainID, &record.Type, &record.Name, &record.Value, &record.TTL, &record.Priority, &record.Weight, &record.ParentRecordID, &record.View, &record.Continent, &record.Country, &record.Backup, &record.CreatedAt, &record.UpdatedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -37,7 +71,7 @@ func (s *service) GetRecordByID(id string) (*models.Record, error) {
 
 func (s *service) GetRecordsByName(domain string, subdomain string) ([]models.Record, error) {
 	query := `
-		SELECT r.id, r.domain_id, r.type, r.name, r.value, r.ttl, r.priority, r.parent_record_id, r.created_at, r.updated_at
+		SELECT r.id, r.domain_id, r.type, r.name, r.value, r.ttl, r.priority, r.weight, r.parent_record_id, r.view, r.continent, r.country, r.backup, r.created_at, r.updated_at
 		FROM records r
 		JOIN domains d ON r.domain_id = d.id
 		WHERE d.domain_name=$1 AND r.name=$2`
@@ -50,7 +84,7 @@ func (s *service) GetRecordsByName(domain string, subdomain string) ([]models.Re
 	var records []models.Record
 	for rows.Next() {
 		var record models.Record
-		err := rows.Scan(&record.ID, &record.DomainID, &record.Type, &record.Name, &record.Value, &record.TTL, &record.Priority, &record.ParentRecordID, &record.CreatedAt, &record.UpdatedAt)
+		err := rows.Scan(&record.ID, &record.DomainID, &record.Type, &record.Name, &record.Value, &record.TTL, &record.Priority, &record.Weight, &record.ParentRecordID, &record.View, &record.Continent, &record.Country, &record.Backup, &record.CreatedAt, &record.UpdatedAt)
 		if err != nil {
 			return nil, err
 		}
@@ -59,11 +93,14 @@ func (s *service) GetRecordsByName(domain string, subdomain string) ([]models.Re
 	return records, nil
 }
 
-func (s *service) GetRecordByDetails(domainID string, recordType string, name string) (*models.Record, error) {
-	query := `SELECT id, domain_id, type, name, value, ttl, priority, parent_record_id, created_at, updated_at FROM records WHERE domain_id=$1 AND type=$2 AND name=$3`
-	row := s.db.QueryRow(query, domainID, recordType, name)
+// GetRecordByDetails finds a record of the RRset with the given type and
+// name served to the same clients, among the backup records if backup is
+// set. A nil value matches any value of the RRset.
+func (s *service) GetRecordByDetails(domainID string, recordType string, name string, value, view, continent, country *string, backup bool) (*models.Record, error) {
+	query := `SELECT id, domain_id, type, name, value, ttl, priority, weight, parent_record_id, view, continent, country, backup, created_at, updated_at FROM records WHERE domain_id=$1 AND type=$2 AND name=$3 AND ($4::text IS NULL OR value=$4) AND view IS NOT DISTINCT FROM $5 AND continent IS NOT DISTINCT FROM $6 AND country IS NOT DISTINCT FROM $7 AND backup=$8 LIMIT 1`
+	row := s.db.QueryRow(query, domainID, recordType, name, value, view, continent, country, backup)
 	var record models.Record
-	err := row.Scan(&record.ID, &record.DomainID, &record.Type, &record.Name, &record.Value, &record.TTL, &record.Priority, &record.ParentRecordID, &record.CreatedAt, &record.UpdatedAt)
+	err := row.Scan(&record.ID, &record.DomainID, &record.Type, &record.Name, &record.Value, &record.TTL, &record.Priority, &record.Weight, &record.ParentRecordID, &record.View, &record.Continent, &record.Country, &record.Backup, &record.CreatedAt, &record.UpdatedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -72,7 +109,7 @@ func (s *service) GetRecordByDetails(domainID string, recordType string, name st
 
 func (s *service) GetRecordsByDomain(domainID string) ([]models.Record, error) {
 	query := `
-		SELECT r.id, r.domain_id, r.type, r.name, r.value, r.ttl, r.priority, r.parent_record_id, r.created_at, r.updated_at, d.domain_name
+		SELECT r.id, r.domain_id, r.type, r.name, r.value, r.ttl, r.priority, r.weight, r.parent_record_id, r.view, r.continent, r.country, r.backup, r.created_at, r.updated_at, d.domain_name
 		FROM records r
 		JOIN domains d 
 		ON d.id = r.domain_id
@@ -86,7 +123,7 @@ func (s *service) GetRecordsByDomain(domainID string) ([]models.Record, error) {
 	var records []models.Record
 	for rows.Next() {
 		var record models.Record
-		err := rows.Scan(&record.ID, &record.DomainID, &record.Type, &record.Name, &record.Value, &record.TTL, &record.Priority, &record.ParentRecordID, &record.CreatedAt, &record.UpdatedAt, &record.DomainName)
+		err := rows.Scan(&record.ID, &record.DomainID, &record.Type, &record.Name, &record.Value, &record.TTL, &record.Priority, &record.Weight, &record.ParentRecordID, &record.View, &record.Continent, &record.Country, &record.Backup, &record.CreatedAt, &record.UpdatedAt, &record.DomainName)
 		if err != nil {
 			return nil, err
 		}
@@ -95,23 +132,229 @@ func (s *service) GetRecordsByDomain(domainID string) ([]models.Record, error) {
 	return records, nil
 }
 
+// UpdateRecord stores the new contents of record, bumps its zone's serial
+// and journals the change as the old record's deletion and the new one's
+// addition, in one transaction.
 func (s *service) UpdateRecord(record *models.Record) error {
 	fmt.Println("Updating record:", record)
-	query := `UPDATE records SET type=$1, name=$2, value=$3, ttl=$4, priority=$5, parent_record_id=$6, updated_at=$7 WHERE id=$8`
-	_, err := s.db.Exec(query,
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var old models.Record
+	err = tx.QueryRow(`SELECT domain_id, type, name, value, ttl, priority, view, continent, country, backup FROM records WHERE id=$1 FOR UPDATE`, record.ID).
+		Scan(&old.DomainID, &old.Type, &old.Name, &old.Value, &old.TTL, &old.Priority, &old.View, &old.Continent, &old.Country, &old.Backup)
+	if err != nil {
+		return err
+	}
+
+	query := `UPDATE records SET type=$1, name=$2, value=$3, ttl=$4, priority=$5, weight=$6, parent_record_id=$7, view=$8, continent=$9, country=$10, backup=$11, updated_at=$12 WHERE id=$13`
+	_, err = tx.Exec(query,
 		record.Type,
 		record.Name,
 		record.Value,
 		record.TTL,
 		record.Priority,
+		record.Weight,
 		record.ParentRecordID,
+		record.View,
+		record.Continent,
+		record.Country,
+		record.Backup,
 		record.UpdatedAt,
 		record.ID,
 	)
-	return err
+	if err != nil {
+		return err
+	}
+
+	if !transferred(&old) && !transferred(record) {
+		return tx.Commit()
+	}
+
+	serial, err := bumpSerial(tx, old.DomainID)
+	if err != nil {
+		return err
+	}
+	if err := journalRecord(tx, serial, "delete", &old); err != nil {
+		return err
+	}
+	if err := journalRecord(tx, serial, "add", record); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
+// DeleteRecord removes a record, bumps its zone's serial and journals the
+// deletion in one transaction.
 func (s *service) DeleteRecord(id string) error {
-	_, err := s.db.Exec(`DELETE FROM records WHERE id=$1`, id)
-	return err
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var old models.Record
+	err = tx.QueryRow(`DELETE FROM records WHERE id=$1 RETURNING domain_id, type, name, value, ttl, priority, view, continent, country, backup`, id).
+		Scan(&old.DomainID, &old.Type, &old.Name, &old.Value, &old.TTL, &old.Priority, &old.View, &old.Continent, &old.Country, &old.Backup)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if !transferred(&old) {
+		return tx.Commit()
+	}
+
+	serial, err := bumpSerial(tx, old.DomainID)
+	if err != nil {
+		return err
+	}
+	if err := journalRecord(tx, serial, "delete", &old); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UpdateZoneRecords makes a batch of record changes to a domain in one
+// transaction that holds the domain's row, so the zone cannot change
+// between plan seeing its records and the changes being made. plan gets
+// the current records and returns the changes in order, or an error to
+// make none. Created records carry their ID. The serial is bumped once for
+// the whole batch and every transferred change is journaled under it.
+func (s *service) UpdateZoneRecords(domainID string, plan func(records []models.Record) ([]models.RecordChange, error)) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id uuid.UUID
+	if err := tx.QueryRow(`SELECT id FROM domains WHERE id=$1 FOR UPDATE`, domainID).Scan(&id); err != nil {
+		return err
+	}
+
+	query := `SELECT id, domain_id, type, name, value, ttl, priority, weight, parent_record_id, view, continent, country, backup, created_at, updated_at FROM records WHERE domain_id=$1`
+	rows, err := tx.Query(query, domainID)
+	if err != nil {
+		return err
+	}
+	var records []models.Record
+	for rows.Next() {
+		var record models.Record
+		err := rows.Scan(&record.ID, &record.DomainID, &record.Type, &record.Name, &record.Value, &record.TTL, &record.Priority, &record.Weight, &record.ParentRecordID, &record.View, &record.Continent, &record.Country, &record.Backup, &record.CreatedAt, &record.UpdatedAt)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		records = append(records, record)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	changes, err := plan(records)
+	if err != nil {
+		return err
+	}
+
+	var serial int64
+	journal := func(action string, record *models.Record) error {
+		if !transferred(record) {
+			return nil
+		}
+		if serial == 0 {
+			if serial, err = bumpSerial(tx, id); err != nil {
+				return err
+			}
+		}
+		return journalRecord(tx, serial, action, record)
+	}
+
+	for i := range changes {
+		record := &changes[i].Record
+		switch changes[i].Action {
+		case "create":
+			query := `
+				INSERT INTO records (id, domain_id, type, name, value, ttl, priority, weight, parent_record_id, view, continent, country, backup, created_at, updated_at)
+				VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15)
+			`
+			_, err := tx.Exec(query,
+				record.ID,
+				id,
+				record.Type,
+				record.Name,
+				record.Value,
+				record.TTL,
+				record.Priority,
+				record.Weight,
+				record.ParentRecordID,
+				record.View,
+				record.Continent,
+				record.Country,
+				record.Backup,
+				record.CreatedAt,
+				record.UpdatedAt,
+			)
+			if err != nil {
+				return err
+			}
+			if err := journal("add", record); err != nil {
+				return err
+			}
+
+		case "update":
+			var old models.Record
+			err := tx.QueryRow(`SELECT domain_id, type, name, value, ttl, priority, view, continent, country, backup FROM records WHERE id=$1 AND domain_id=$2`, record.ID, id).
+				Scan(&old.DomainID, &old.Type, &old.Name, &old.Value, &old.TTL, &old.Priority, &old.View, &old.Continent, &old.Country, &old.Backup)
+			if err != nil {
+				return err
+			}
+			query := `UPDATE records SET type=$1, name=$2, value=$3, ttl=$4, priority=$5, weight=$6, parent_record_id=$7, view=$8, continent=$9, country=$10, backup=$11, updated_at=$12 WHERE id=$13`
+			_, err = tx.Exec(query,
+				record.Type,
+				record.Name,
+				record.Value,
+				record.TTL,
+				record.Priority,
+				record.Weight,
+				record.ParentRecordID,
+				record.View,
+				record.Continent,
+				record.Country,
+				record.Backup,
+				record.UpdatedAt,
+				record.ID,
+			)
+			if err != nil {
+				return err
+			}
+			if err := journal("delete", &old); err != nil {
+				return err
+			}
+			if err := journal("add", record); err != nil {
+				return err
+			}
+
+		case "delete":
+			var old models.Record
+			err := tx.QueryRow(`DELETE FROM records WHERE id=$1 AND domain_id=$2 RETURNING domain_id, type, name, value, ttl, priority, view, continent, country, backup`, record.ID, id).
+				Scan(&old.DomainID, &old.Type, &old.Name, &old.Value, &old.TTL, &old.Priority, &old.View, &old.Continent, &old.Country, &old.Backup)
+			if err != nil {
+				return err
+			}
+			if err := journal("delete", &old); err != nil {
+				return err
+			}
+
+		default:
+			return fmt.Errorf("unknown record change %q", changes[i].Action)
+		}
+	}
+	return tx.Commit()
 }
@@ -0,0 +1,53 @@
+package database
+
+import "dns-server/internal/models"
+
+func (s *service) CreateSecondary(secondary *models.Secondary) error {
+	query := `
+		INSERT INTO zone_secondaries (domain_id, address, tsig_key_name, created_at)
+		VALUES ($1,$2,$3,$4)
+		RETURNING id
+	`
+	return s.db.QueryRow(query,
+		secondary.DomainID,
+		secondary.Address,
+		secondary.TSIGKeyName,
+		secondary.CreatedAt,
+	).Scan(&secondary.ID)
+}
+
+func (s *service) GetSecondaryByID(id string) (*models.Secondary, error) {
+	query := `SELECT id, domain_id, address, tsig_key_name, created_at FROM zone_secondaries WHERE id=$1`
+	row := s.db.QueryRow(query, id)
+	var secondary models.Secondary
+	err := row.Scan(&secondary.ID, &secondary.DomainID, &secondary.Address, &secondary.TSIGKeyName, &secondary.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &secondary, nil
+}
+
+func (s *service) GetSecondariesByDomain(domainID string) ([]models.Secondary, error) {
+	query := `SELECT id, domain_id, address, tsig_key_name, created_at FROM zone_secondaries WHERE domain_id=$1 ORDER BY created_at`
+	rows, err := s.db.Query(query, domainID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var secondaries []models.Secondary
+	for rows.Next() {
+		var secondary models.Secondary
+		err := rows.Scan(&secondary.ID, &secondary.DomainID, &secondary.Address, &secondary.TSIGKeyName, &secondary.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		secondaries = append(secondaries, secondary)
+	}
+	return secondaries, nil
+}
+
+func (s *service) DeleteSecondary(id string) error {
+	_, err := s.db.Exec(`DELETE FROM zone_secondaries WHERE id=$1`, id)
+	return err
+}
@@ -0,0 +1,91 @@
+package database
+
+import (
+	"dns-server/internal/models"
+	"time"
+)
+
+// GetSecondaryRecords returns the transferred records of a secondary zone.
+// Each record's Value holds its data in zone file format.
+func (s *service) GetSecondaryRecords(domainID string) ([]models.Record, error) {
+	query := `SELECT id, domain_id, name, type, ttl, rdata FROM secondary_records WHERE domain_id=$1`
+	rows, err := s.db.Query(query, domainID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []models.Record
+	for rows.Next() {
+		var record models.Record
+		err := rows.Scan(&record.ID, &record.DomainID, &record.Name, &record.Type, &record.TTL, &record.Value)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// ReplaceSecondaryRecords swaps the data of a secondary zone for records
+// transferred at serial, in one transaction.
+func (s *service) ReplaceSecondaryRecords(domainID string, serial int64, records []models.Record) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM secondary_records WHERE domain_id=$1`, domainID); err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(`INSERT INTO secondary_records (domain_id, name, type, ttl, rdata) VALUES ($1,$2,$3,$4,$5)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, record := range records {
+		if _, err := stmt.Exec(domainID, record.Name, record.Type, record.TTL, record.Value); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(`UPDATE domains SET serial=$1, updated_at=$2 WHERE id=$3`, serial, time.Now(), domainID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetDueSecondaryZones returns the verified secondary zones whose refresh is
+// due at now, including those that were never transferred. Unverified ones
+// are not contacted: their primary is whatever the user typed.
+func (s *service) GetDueSecondaryZones(now time.Time) ([]models.Domain, error) {
+	query := `
+		SELECT id, user_id, domain_name, verified, dnssec, serial, primary_address, primary_tsig_key, refresh_at, expires_at, created_at, updated_at
+		FROM domains
+		WHERE primary_address IS NOT NULL AND verified AND (refresh_at IS NULL OR refresh_at <= $1)`
+	rows, err := s.db.Query(query, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var domains []models.Domain
+	for rows.Next() {
+		var domain models.Domain
+		err := rows.Scan(&domain.ID, &domain.UserID, &domain.DomainName, &domain.Verified, &domain.DNSSEC, &domain.Serial, &domain.Primary, &domain.PrimaryTSIGKey, &domain.RefreshAt, &domain.ExpiresAt, &domain.CreatedAt, &domain.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		domains = append(domains, domain)
+	}
+	return domains, nil
+}
+
+// SetSecondaryZoneTimers schedules the next refresh of a secondary zone and,
+// unless expiresAt is nil, moves its expiry.
+func (s *service) SetSecondaryZoneTimers(domainID string, refreshAt time.Time, expiresAt *time.Time) error {
+	query := `UPDATE domains SET refresh_at=$1, expires_at=COALESCE($2, expires_at) WHERE id=$3`
+	_, err := s.db.Exec(query, refreshAt, expiresAt, domainID)
+	return err
+}
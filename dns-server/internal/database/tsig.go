@@ -0,0 +1,77 @@
+package database
+
+import "dns-server/internal/models"
+
+func (s *service) CreateTSIGKey(key *models.TSIGKey) error {
+	query := `
+		INSERT INTO tsig_keys (domain_id, user_id, name, algorithm, secret, can_transfer, can_update, created_at, updated_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)
+		RETURNING id
+	`
+	return s.db.QueryRow(query,
+		key.DomainID,
+		key.UserID,
+		key.Name,
+		key.Algorithm,
+		key.Secret,
+		key.CanTransfer,
+		key.CanUpdate,
+		key.CreatedAt,
+		key.UpdatedAt,
+	).Scan(&key.ID)
+}
+
+func (s *service) GetTSIGKeyByID(id string) (*models.TSIGKey, error) {
+	query := `SELECT id, domain_id, user_id, name, algorithm, secret, can_transfer, can_update, created_at, updated_at FROM tsig_keys WHERE id=$1`
+	row := s.db.QueryRow(query, id)
+	var key models.TSIGKey
+	err := row.Scan(&key.ID, &key.DomainID, &key.UserID, &key.Name, &key.Algorithm, &key.Secret, &key.CanTransfer, &key.CanUpdate, &key.CreatedAt, &key.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (s *service) GetTSIGKeyByName(name string) (*models.TSIGKey, error) {
+	query := `SELECT id, domain_id, user_id, name, algorithm, secret, can_transfer, can_update, created_at, updated_at FROM tsig_keys WHERE name=$1`
+	row := s.db.QueryRow(query, name)
+	var key models.TSIGKey
+	err := row.Scan(&key.ID, &key.DomainID, &key.UserID, &key.Name, &key.Algorithm, &key.Secret, &key.CanTransfer, &key.CanUpdate, &key.CreatedAt, &key.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (s *service) GetTSIGKeysByDomain(domainID string) ([]models.TSIGKey, error) {
+	query := `SELECT id, domain_id, user_id, name, algorithm, secret, can_transfer, can_update, created_at, updated_at FROM tsig_keys WHERE domain_id=$1 ORDER BY created_at`
+	rows, err := s.db.Query(query, domainID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []models.TSIGKey
+	for rows.Next() {
+		var key models.TSIGKey
+		err := rows.Scan(&key.ID, &key.DomainID, &key.UserID, &key.Name, &key.Algorithm, &key.Secret, &key.CanTransfer, &key.CanUpdate, &key.CreatedAt, &key.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// UpdateTSIGKey stores the permissions of a key. Its name, algorithm and
+// secret never change; a compromised key is replaced by a new one.
+func (s *service) UpdateTSIGKey(key *models.TSIGKey) error {
+	query := `UPDATE tsig_keys SET can_transfer=$1, can_update=$2, updated_at=$3 WHERE id=$4`
+	_, err := s.db.Exec(query, key.CanTransfer, key.CanUpdate, key.UpdatedAt, key.ID)
+	return err
+}
+
+func (s *service) DeleteTSIGKey(id string) error {
+	_, err := s.db.Exec(`DELETE FROM tsig_keys WHERE id=$1`, id)
+	return err
+}
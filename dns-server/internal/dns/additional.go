@@ -0,0 +1,67 @@
+package dns
+
+import (
+	"log"
+
+	"github.com/google/uuid"
+	"github.com/miekg/dns"
+)
+
+// addAdditional puts the A and AAAA records of targets named in the ANSWER
+// and AUTHORITY sections into ADDITIONAL, so resolvers can reach name
+// servers, mail exchangers and services without another query. Only
+// targets inside zones we host are looked up; anything else is left for
+// the resolver. Glue below a delegation is never signed.
+func (s *DNSServer) addAdditional(m *dns.Msg, do bool, c client, zones map[uuid.UUID]*zone) {
+	// Names already answered need no extra addresses
+	seen := make(map[string]bool)
+	for _, rr := range m.Answer {
+		seen[normalizeName(rr.Header().Name)] = true
+	}
+
+	for _, target := range additionalTargets(m) {
+		key := normalizeName(target)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		z, owner, err := s.zoneFor(target, c, zones)
+		if err != nil {
+			log.Printf("DB query error for additional %s: %v", target, err)
+			continue
+		}
+		if z == nil {
+			continue
+		}
+		addresses := append(s.answerRRset(z, target, owner, dns.TypeA), s.answerRRset(z, target, owner, dns.TypeAAAA)...)
+		if _, glue := z.delegation(owner); !glue {
+			addresses = z.sign(addresses, do, "")
+		}
+		m.Extra = append(m.Extra, addresses...)
+	}
+}
+
+// additionalTargets lists the host names referenced by NS, MX, SRV and CNAME
+// records in the ANSWER section and NS records in AUTHORITY.
+func additionalTargets(m *dns.Msg) []string {
+	var targets []string
+	for _, rr := range m.Answer {
+		switch rr := rr.(type) {
+		case *dns.NS:
+			targets = append(targets, rr.Ns)
+		case *dns.MX:
+			targets = append(targets, rr.Mx)
+		case *dns.SRV:
+			targets = append(targets, rr.Target)
+		case *dns.CNAME:
+			targets = append(targets, rr.Target)
+		}
+	}
+	for _, rr := range m.Ns {
+		if ns, ok := rr.(*dns.NS); ok {
+			targets = append(targets, ns.Ns)
+		}
+	}
+	return targets
+}
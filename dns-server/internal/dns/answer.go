@@ -0,0 +1,149 @@
+package dns
+
+import (
+	"log"
+
+	"github.com/google/uuid"
+	"github.com/miekg/dns"
+)
+
+// maxCNAMEChain bounds how many aliases are followed for a single question.
+const maxCNAMEChain = 8
+
+// answer fills m with the authoritative response to q. do asks for DNSSEC
+// records along with the answer, and c decides which variant of each zone
+// is used.
+func (s *DNSServer) answer(q dns.Question, m *dns.Msg, do bool, c client) {
+	// Everything we serve lives in class IN
+	if q.Qclass != dns.ClassINET && q.Qclass != dns.ClassANY {
+		m.Authoritative = false
+		m.Rcode = dns.RcodeRefused
+		return
+	}
+
+	zones := make(map[uuid.UUID]*zone)
+	s.resolve(q, m, do, c, zones)
+	s.addAdditional(m, do, c, zones)
+	c.scopeSubnet(zones)
+}
+
+// resolve answers q from the hosted zones, following CNAME chains. zones
+// caches the zones loaded along the way.
+func (s *DNSServer) resolve(q dns.Question, m *dns.Msg, do bool, c client, zones map[uuid.UUID]*zone) {
+	visited := make(map[string]bool)
+	qname := q.Name
+
+	for {
+		z, owner, err := s.zoneFor(qname, c, zones)
+		if err != nil {
+			log.Printf("DB query error for %s: %v", qname, err)
+			m.Rcode = dns.RcodeServerFailure
+			return
+		}
+		if z == nil {
+			// None of the name's suffixes is a domain we host. After an
+			// alias this just means the chain leaves our zones.
+			if len(m.Answer) == 0 {
+				m.Rcode = dns.RcodeNameError
+			}
+			return
+		}
+		if z.expired() {
+			log.Printf("Secondary zone %s is expired or not transferred yet", z.DomainName)
+			m.Authoritative = false
+			m.Rcode = dns.RcodeServerFailure
+			return
+		}
+
+		// Names at or below a delegation are answered with a referral to the
+		// child's servers, except DS which lives on the parent side of the cut
+		if cut, ok := z.delegation(owner); ok && !(cut == owner && q.Qtype == dns.TypeDS) {
+			m.Authoritative = false
+			m.Ns = append(m.Ns, z.rrset(z.fqdn(cut), cut, dns.TypeNS)...)
+			// The NSEC at the cut proves the child has no DS, i.e. is unsigned
+			m.Ns = append(m.Ns, z.denial(do, z.fqdn(cut))...)
+			return
+		}
+
+		// node is where the records live: owner itself, or the wildcard
+		// synthesizing answers for it
+		node, wildcard := owner, ""
+		if !z.exists(owner) {
+			source, ok := z.wildcard(owner)
+			if !ok {
+				// The name has no records and nothing below it, so it does
+				// not exist. After an alias the rcode describes the last
+				// name in the chain (RFC 6604).
+				m.Rcode = dns.RcodeNameError
+				m.Ns = append(m.Ns, z.negative(do)...)
+				m.Ns = append(m.Ns, z.denial(do, qname, z.fqdn(source))...)
+				return
+			}
+			node, wildcard = source, z.fqdn(source)
+		}
+
+		if answers := s.answerRRset(z, qname, node, q.Qtype); len(answers) > 0 {
+			m.Answer = append(m.Answer, z.sign(answers, do, wildcard)...)
+			if wildcard != "" {
+				// Prove the query name itself does not exist
+				m.Ns = append(m.Ns, z.denial(do, qname)...)
+			}
+
+			// Include the apex NS set in AUTHORITY unless it already is the answer
+			if owner != "@" || (q.Qtype != dns.TypeNS && q.Qtype != dns.TypeANY) {
+				m.Ns = append(m.Ns, z.sign(z.rrset(z.origin, "@", dns.TypeNS), do, "")...)
+			}
+			return
+		}
+
+		// An alias answers for every type at its name
+		var cname []dns.RR
+		if q.Qtype != dns.TypeCNAME {
+			cname = s.answerRRset(z, qname, node, dns.TypeCNAME)
+		}
+		if len(cname) == 0 {
+			// NODATA: the name exists but holds nothing of this type
+			m.Ns = append(m.Ns, z.negative(do)...)
+			if wildcard != "" {
+				m.Ns = append(m.Ns, z.denial(do, wildcard, qname)...)
+			} else {
+				m.Ns = append(m.Ns, z.denial(do, qname)...)
+			}
+			return
+		}
+		m.Answer = append(m.Answer, z.sign(cname[:1], do, wildcard)...)
+		if wildcard != "" {
+			m.Ns = append(m.Ns, z.denial(do, qname)...)
+		}
+
+		// Follow the alias as long as it stays inside zones we host
+		visited[normalizeName(qname)] = true
+		target := cname[0].(*dns.CNAME).Target
+		if visited[normalizeName(target)] {
+			log.Printf("CNAME loop at %s while resolving %s", target, q.Name)
+			return
+		}
+		if len(visited) >= maxCNAMEChain {
+			log.Printf("CNAME chain for %s longer than %d", q.Name, maxCNAMEChain)
+			return
+		}
+		qname = target
+	}
+}
+
+// zoneFor finds the hosted zone containing name as seen by c, reusing
+// zones already used for the current question so it sees a single version
+// of each. A nil zone means name is not inside any zone we host.
+func (s *DNSServer) zoneFor(name string, c client, loaded map[uuid.UUID]*zone) (*zone, string, error) {
+	z, owner, err := s.cachedZone(name)
+	if err != nil || z == nil {
+		return nil, "", err
+	}
+	z = z.forView(c.view).forLocation(c.location)
+
+	if seen, ok := loaded[z.ID]; ok {
+		return seen, owner, nil
+	}
+	loaded[z.ID] = z
+	return z, owner, nil
+}
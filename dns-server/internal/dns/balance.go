@@ -0,0 +1,108 @@
+package dns
+
+import (
+	"dns-server/internal/models"
+	"math"
+	"math/rand/v2"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/miekg/dns"
+)
+
+// answerRRset returns the answer RRset of the given type at owner, named
+// qname, in the order and size used for load distribution. Records whose
+// target failed its health check are left out, and when none is left the
+// healthy backup records answer instead. When any of the records has a
+// weight they are shuffled so each comes first in proportion to its
+// weight, otherwise the order rotates from one response to the next. At
+// most maxAnswers records are returned.
+func (s *DNSServer) answerRRset(z *zone, qname, owner string, qtype uint16) []dns.RR {
+	if qtype == dns.TypeANY {
+		return z.rrset(qname, owner, qtype)
+	}
+
+	records := s.healthyRecords(z.records[owner], qtype)
+	if len(records) == 0 {
+		records = s.healthyRecords(z.backups[owner], qtype)
+	}
+	if len(records) == 0 {
+		// With every target down, answering with all of them still beats
+		// answering with none
+		records = recordsOfType(z.records[owner], qtype)
+	}
+
+	if len(records) > 1 {
+		weighted := false
+		for _, record := range records {
+			weighted = weighted || record.Weight != nil
+		}
+		if weighted {
+			records = weightedOrder(records)
+		} else {
+			records = rotate(records, int(s.rotation.Add(1)%uint64(len(records))))
+		}
+		if s.maxAnswers > 0 && len(records) > s.maxAnswers {
+			records = records[:s.maxAnswers]
+		}
+	}
+	return z.recordsToRRs(qname, owner, qtype, records)
+}
+
+// recordsOfType returns the records of type qtype.
+func recordsOfType(records []models.Record, qtype uint16) []models.Record {
+	var out []models.Record
+	for _, record := range records {
+		if dns.StringToType[record.Type] == qtype {
+			out = append(out, record)
+		}
+	}
+	return out
+}
+
+// weightedOrder shuffles records so that each is as likely to come first as
+// its share of the total weight, and so on for the records after it
+// (Efraimidis-Spirakis). Records without a weight count as weight 1, and
+// records of weight 0 are left out unless all of them are weight 0.
+func weightedOrder(records []models.Record) []models.Record {
+	type keyed struct {
+		record models.Record
+		key    float64
+	}
+	var out []keyed
+	for _, record := range records {
+		weight := 1
+		if record.Weight != nil {
+			weight = *record.Weight
+		}
+		if weight > 0 {
+			out = append(out, keyed{record, math.Pow(rand.Float64(), 1/float64(weight))})
+		}
+	}
+	if len(out) == 0 {
+		return rotate(records, rand.IntN(len(records)))
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].key > out[j].key })
+	ordered := make([]models.Record, len(out))
+	for i, k := range out {
+		ordered[i] = k.record
+	}
+	return ordered
+}
+
+// rotate returns records starting at the one at index first.
+func rotate(records []models.Record, first int) []models.Record {
+	return append(append([]models.Record(nil), records[first:]...), records[:first]...)
+}
+
+// maxAnswersFromEnv reads DNS_MAX_ANSWERS, the most values of an RRset
+// returned in one answer. 0, the default, returns all of them.
+func maxAnswersFromEnv() int {
+	n, err := strconv.Atoi(os.Getenv("DNS_MAX_ANSWERS"))
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
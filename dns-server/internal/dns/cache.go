@@ -0,0 +1,247 @@
+package dns
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// zoneCacheRetry is how long to wait before listening for zone changes
+// again after the connection was lost.
+const zoneCacheRetry = 5 * time.Second
+
+// zoneCache keeps every hosted zone in memory so questions are answered
+// without a database round trip. It is filled once notifications about
+// zone changes are being received and kept current by reloading a zone
+// each time one arrives for it. When it stops being current its content is
+// kept as the last good data to fall back on while the database is down.
+type zoneCache struct {
+	mu sync.RWMutex
+	// zones maps normalized domain names to their zone
+	zones map[string]*zone
+	// names maps domain IDs to the name their zone is cached under
+	names map[uuid.UUID]string
+	// current is set while the cache is complete and receiving changes
+	current bool
+	// staleSince is when the cache was last known to be current, zero
+	// while it does not hold a complete set of zones
+	staleSince time.Time
+	// changed is set when zones changed since the last snapshot
+	changed bool
+}
+
+func newZoneCache() *zoneCache {
+	return &zoneCache{zones: make(map[string]*zone), names: make(map[uuid.UUID]string)}
+}
+
+// state reports whether the cache is current and, if it is not, since when
+// its content may be stale.
+func (c *zoneCache) state() (current bool, staleSince time.Time) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.current, c.staleSince
+}
+
+// find returns the cached zone authoritative for name, like findZone, and
+// the owner name relative to it.
+func (c *zoneCache) find(name string) (*zone, string) {
+	name = normalizeName(name)
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, candidate := range zoneCandidates(name) {
+		if z := c.zones[candidate]; z != nil {
+			return z, relativeName(name, candidate)
+		}
+	}
+	return nil, ""
+}
+
+// replace swaps the whole content of the cache for zones. current tells
+// whether they are known to be up to date; otherwise they were last at
+// staleSince.
+func (c *zoneCache) replace(zones []*zone, current bool, staleSince time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.zones = make(map[string]*zone, len(zones))
+	c.names = make(map[uuid.UUID]string, len(zones))
+	for _, z := range zones {
+		c.zones[z.DomainName] = z
+		c.names[z.ID] = z.DomainName
+	}
+	c.current = current
+	c.staleSince = staleSince
+	c.changed = current
+}
+
+// set caches z, replacing any earlier version of the same domain.
+func (c *zoneCache) set(z *zone) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if name, ok := c.names[z.ID]; ok {
+		delete(c.zones, name)
+	}
+	c.zones[z.DomainName] = z
+	c.names[z.ID] = z.DomainName
+	c.changed = true
+}
+
+// remove drops the zone of a deleted domain.
+func (c *zoneCache) remove(id uuid.UUID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if name, ok := c.names[id]; ok {
+		delete(c.zones, name)
+		delete(c.names, id)
+		c.changed = true
+	}
+}
+
+// invalidate marks the cache as no longer current. Its zones are kept as
+// they were at this moment.
+func (c *zoneCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.current {
+		c.current = false
+		c.staleSince = time.Now()
+	}
+}
+
+// runZoneCache keeps the zone cache in sync with the database. Each time
+// listening starts the cache is reloaded in full, as changes may have been
+// missed in between. Questions fall back to the database whenever the cache
+// is not known to be current.
+func (s *DNSServer) runZoneCache() {
+	for {
+		ctx, cancel := context.WithCancel(context.Background())
+		err := s.db.ListenZoneChanges(ctx, func(domainID string) {
+			var err error
+			if domainID == "" {
+				err = s.reloadZones()
+			} else {
+				err = s.reloadZone(domainID)
+			}
+			if err != nil {
+				// Start over rather than serve a zone we know is stale
+				log.Printf("Failed to reload zone cache: %v", err)
+				cancel()
+			}
+		})
+		cancel()
+		s.zones.invalidate()
+		log.Printf("Zone cache not receiving changes, answering from the database: %v", err)
+		time.Sleep(zoneCacheRetry)
+	}
+}
+
+// reloadZones loads every hosted zone, the verified domains, into the cache.
+func (s *DNSServer) reloadZones() error {
+	domains, err := s.db.GetVerifiedDomains()
+	if err != nil {
+		return err
+	}
+
+	zones := make([]*zone, 0, len(domains))
+	for i := range domains {
+		z, err := s.loadZone(&domains[i])
+		if err != nil {
+			return err
+		}
+		zones = append(zones, z)
+	}
+	s.zones.replace(zones, true, time.Time{})
+	s.staleRecovered()
+	log.Printf("Zone cache loaded %d zones", len(zones))
+	return nil
+}
+
+// reloadZone refreshes the cached zone of one domain after a change, or
+// drops it when the domain is gone or not verified.
+func (s *DNSServer) reloadZone(domainID string) error {
+	id, err := uuid.Parse(domainID)
+	if err != nil {
+		return err
+	}
+
+	domain, err := s.db.GetDomainByID(domainID)
+	if errors.Is(err, sql.ErrNoRows) || err == nil && !domain.Verified {
+		s.zones.remove(id)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	z, err := s.loadZone(domain)
+	if err != nil {
+		return err
+	}
+	s.zones.set(z)
+	return nil
+}
+
+// cachedZone is the zone lookup used when answering questions: the zone
+// cache when it is current, otherwise the database. Should the database
+// fail too, the last good data is served for up to serveStale after the
+// cache stopped being current (RFC 8767).
+func (s *DNSServer) cachedZone(name string) (*zone, string, error) {
+	current, staleSince := s.zones.state()
+	if current {
+		z, owner := s.zones.find(name)
+		return z, owner, nil
+	}
+
+	z, owner, err := s.databaseZone(name)
+	if err == nil {
+		s.staleRecovered()
+		if z != nil {
+			// Keep the last good data in case the database fails later
+			s.zones.set(z)
+		}
+		return z, owner, nil
+	}
+
+	if staleSince.IsZero() || time.Since(staleSince) > s.serveStale {
+		return nil, "", err
+	}
+	s.servingStale(err, staleSince)
+	z, owner = s.zones.find(name)
+	return z, owner, nil
+}
+
+// databaseZone finds and loads the zone authoritative for name from the
+// database.
+func (s *DNSServer) databaseZone(name string) (*zone, string, error) {
+	domain, owner, err := s.findZone(name)
+	if err != nil || domain == nil {
+		return nil, "", err
+	}
+	z, err := s.loadZone(domain)
+	if err != nil {
+		return nil, "", err
+	}
+	return z, owner, nil
+}
+
+// servingStale counts a lookup answered from stale data and logs when
+// stale answers start.
+func (s *DNSServer) servingStale(err error, staleSince time.Time) {
+	staleAnswers.Add(1)
+	if s.stale.CompareAndSwap(false, true) {
+		servingStale.Set(1)
+		log.Printf("Database unavailable (%v), serving zone data last known current at %s", err, staleSince.Format(time.RFC3339))
+	}
+}
+
+// staleRecovered logs when fresh data is served again after stale answers.
+func (s *DNSServer) staleRecovered() {
+	if s.stale.CompareAndSwap(true, false) {
+		servingStale.Set(0)
+		log.Printf("Database available again, no longer serving stale zone data")
+	}
+}
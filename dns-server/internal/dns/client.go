@@ -0,0 +1,87 @@
+package dns
+
+import (
+	"dns-server/internal/services"
+	"net"
+
+	"github.com/google/uuid"
+	"github.com/miekg/dns"
+)
+
+// client is who a question is answered for, which decides the variant of
+// a zone served to it.
+type client struct {
+	// view is the split-horizon view of the client, "" for none
+	view string
+	// location is where the client is, zero when unknown
+	location services.Location
+	// subnet is the EDNS Client Subnet option echoed in the response, if
+	// any, whose scope is widened once the answer depends on the client
+	subnet *dns.EDNS0_SUBNET
+}
+
+// clientOf describes the client of r. The EDNS Client Subnet option (RFC
+// 7871) stands in for the resolver's own address: always for the
+// client's location, but for its view only when the resolver is trusted.
+// An option that was used is echoed in m with scope 0, meaning the answer
+// holds for every client, until scopeSubnet finds otherwise.
+func (s *DNSServer) clientOf(w dns.ResponseWriter, r, m *dns.Msg) client {
+	ip := remoteIP(w.RemoteAddr())
+	viewIP, locationIP := ip, ip
+	var c client
+	if ecs := clientSubnet(r); ecs != nil && ecs.SourceNetmask > 0 {
+		locationIP = ecs.Address
+		if services.ContainsIP(s.ecsTrusted, ip) {
+			viewIP = ecs.Address
+		}
+		if opt := m.IsEdns0(); opt != nil {
+			reply := *ecs
+			reply.SourceScope = 0
+			opt.Option = append(opt.Option, &reply)
+			c.subnet = &reply
+		}
+	}
+
+	c.view = s.viewOf(viewIP)
+	c.location, _ = services.LookupLocation(locationIP)
+	return c
+}
+
+// scopeSubnet scopes the echoed Client Subnet option of c to the whole subnet
+// the client sent when the answer came from a zone that has view or
+// geographic variants, so resolvers only reuse it for that subnet.
+func (c client) scopeSubnet(zones map[uuid.UUID]*zone) {
+	if c.subnet == nil {
+		return
+	}
+	for _, z := range zones {
+		if z.tailored {
+			c.subnet.SourceScope = c.subnet.SourceNetmask
+			return
+		}
+	}
+}
+
+// viewOf returns the first view listing the network of ip, or "".
+func (s *DNSServer) viewOf(ip net.IP) string {
+	for _, view := range s.views {
+		if services.ContainsIP(view.Networks, ip) {
+			return view.Name
+		}
+	}
+	return ""
+}
+
+// clientSubnet returns the EDNS Client Subnet option of r, if any.
+func clientSubnet(r *dns.Msg) *dns.EDNS0_SUBNET {
+	opt := r.IsEdns0()
+	if opt == nil {
+		return nil
+	}
+	for _, o := range opt.Option {
+		if ecs, ok := o.(*dns.EDNS0_SUBNET); ok {
+			return ecs
+		}
+	}
+	return nil
+}
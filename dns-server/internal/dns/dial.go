@@ -0,0 +1,28 @@
+package dns
+
+import (
+	"dns-server/internal/services"
+	"errors"
+	"net"
+	"syscall"
+)
+
+// errTargetNotAllowed is returned when an address given by a user, such as
+// a health check target or the primary of a secondary zone, resolves to
+// an address of this host or of a private network.
+var errTargetNotAllowed = errors.New("target address not allowed")
+
+// dialPublicOnly is the net.Dialer Control hook for connections to
+// addresses given by users. It runs as each address is dialed, after name
+// resolution, so a name cannot be pointed at the networks behind the
+// server once it passed validation.
+func dialPublicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if !services.IsPublicIP(net.ParseIP(host)) {
+		return errTargetNotAllowed
+	}
+	return nil
+}
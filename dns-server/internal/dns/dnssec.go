@@ -0,0 +1,342 @@
+package dns
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"dns-server/internal/models"
+	"dns-server/internal/services"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// signatureValidity is how long an online signature stays valid
+	signatureValidity = 7 * 24 * time.Hour
+	// signatureBackdate covers resolvers whose clocks run behind ours
+	signatureBackdate = time.Hour
+	// resignInterval is the longest the serial of a signed zone with
+	// secondaries stays the same, so they transfer fresh signatures well
+	// before the ones they hold expire
+	resignInterval = signatureValidity / 3
+	// signatureReuse is how long after it was made a signature is handed
+	// out again instead of signing afresh, leaving every answer at least
+	// half the validity period
+	signatureReuse = signatureValidity / 2
+	// maxCachedSignatures bounds the signatures kept; the cache starts over
+	// when it is full
+	maxCachedSignatures = 100000
+)
+
+// signingKey is a zone key ready for online signing.
+type signingKey struct {
+	dnskey *dns.DNSKEY
+	signer crypto.Signer
+}
+
+// zoneKeys holds the DNSSEC keys of a signed zone.
+type zoneKeys struct {
+	// ksks sign the DNSKEY RRset, zsks everything else
+	ksks, zsks []signingKey
+	// dnskeys is the apex DNSKEY RRset
+	dnskeys []dns.RR
+	// cds and cdnskey tell the parent which KSKs to hold a DS for (RFC 7344)
+	cds, cdnskey []dns.RR
+}
+
+// signatures caches the RRSIGs made for every zone. Zones are rebuilt on
+// every change, and per question while the zone cache is not current, so
+// signatures are kept apart from them, by zone version, key and records.
+var signatures = struct {
+	sync.Mutex
+	sigs map[[sha256.Size]byte]*dns.RRSIG
+}{sigs: make(map[[sha256.Size]byte]*dns.RRSIG)}
+
+// newZoneKeys prepares the keys of a zone for signing according to their
+// rollover state. It returns nil when the zone has no usable signing keys
+// and is therefore served unsigned.
+func newZoneKeys(keys []models.DNSSECKey, origin string) *zoneKeys {
+	zk := &zoneKeys{}
+	for i := range keys {
+		if keys[i].State == services.KeyRemoved {
+			continue
+		}
+		signer, err := services.DNSSECSigner(&keys[i], origin)
+		if err != nil {
+			log.Printf("Skipping DNSSEC key %d of %s: %v", keys[i].KeyTag, origin, err)
+			continue
+		}
+		key := signingKey{dnskey: services.DNSKEY(&keys[i], origin), signer: signer}
+		zk.dnskeys = append(zk.dnskeys, key.dnskey)
+
+		switch {
+		case keys[i].KeyType == "KSK" && keys[i].State != services.KeyPublished:
+			// A retired KSK keeps signing the DNSKEY RRset until the
+			// parent's DS for it has expired (double signature rollover)
+			zk.ksks = append(zk.ksks, key)
+		case keys[i].KeyType == "ZSK" && keys[i].State == services.KeyActive:
+			zk.zsks = append(zk.zsks, key)
+		}
+	}
+
+	if len(zk.ksks) == 0 && len(zk.zsks) == 0 {
+		return nil
+	}
+	// A lone key signs everything, like a combined signing key
+	if len(zk.zsks) == 0 {
+		zk.zsks = zk.ksks
+	}
+	if len(zk.ksks) == 0 {
+		zk.ksks = zk.zsks
+	}
+
+	for _, key := range services.ParentKeys(keys, origin) {
+		zk.cds = append(zk.cds, key.ToDS(dns.SHA256).ToCDS())
+		zk.cdnskey = append(zk.cdnskey, key.ToCDNSKEY())
+	}
+	return zk
+}
+
+// sign returns rrs followed by the RRSIGs covering each of its RRsets, or
+// rrs alone when do is false or the zone is unsigned. wildcard is the fully
+// qualified wildcard owner that synthesized rrs, or empty, so the signature
+// carries the label count validators need to reconstruct it (RFC 4035).
+func (z *zone) sign(rrs []dns.RR, do bool, wildcard string) []dns.RR {
+	if !do || z.keys == nil || len(rrs) == 0 {
+		return rrs
+	}
+
+	out := append([]dns.RR(nil), rrs...)
+	for _, rrset := range splitRRsets(rrs) {
+		keys := z.keys.zsks
+		switch rrset[0].Header().Rrtype {
+		case dns.TypeDNSKEY, dns.TypeCDS, dns.TypeCDNSKEY:
+			// RFC 7344 wants CDS and CDNSKEY signed by a key the parent knows
+			keys = z.keys.ksks
+		}
+		for _, key := range keys {
+			if sig := z.signRRset(rrset, key, wildcard); sig != nil {
+				out = append(out, sig)
+			}
+		}
+	}
+	return out
+}
+
+// signRRset creates one RRSIG over rrset with key, or reuses the one made
+// for the same records before while it has long enough to go.
+func (z *zone) signRRset(rrset []dns.RR, key signingKey, wildcard string) dns.RR {
+	owner := rrset[0].Header().Name
+	if wildcard != "" {
+		// Sign the records as they are stored, under the wildcard name
+		stored := make([]dns.RR, len(rrset))
+		for i, rr := range rrset {
+			stored[i] = dns.Copy(rr)
+			stored[i].Header().Name = wildcard
+		}
+		rrset = stored
+	}
+
+	now := time.Now()
+	id := z.signatureID(rrset, key)
+	signatures.Lock()
+	cached := signatures.sigs[id]
+	signatures.Unlock()
+	if cached != nil {
+		made := time.Unix(int64(cached.Expiration), 0).Add(-signatureValidity)
+		if now.Sub(made) < signatureReuse {
+			sig := dns.Copy(cached)
+			sig.Header().Name = owner
+			return sig
+		}
+	}
+
+	sig := &dns.RRSIG{
+		Hdr:        dns.RR_Header{Ttl: rrset[0].Header().Ttl},
+		Algorithm:  key.dnskey.Algorithm,
+		KeyTag:     key.dnskey.KeyTag(),
+		SignerName: z.origin,
+		Inception:  uint32(now.Add(-signatureBackdate).Unix()),
+		Expiration: uint32(now.Add(signatureValidity).Unix()),
+	}
+	if err := sig.Sign(key.signer, rrset); err != nil {
+		log.Printf("Failed to sign %s/%s in %s: %v", owner, dns.TypeToString[rrset[0].Header().Rrtype], z.DomainName, err)
+		return nil
+	}
+
+	signatures.Lock()
+	if len(signatures.sigs) >= maxCachedSignatures {
+		signatures.sigs = make(map[[sha256.Size]byte]*dns.RRSIG)
+	}
+	signatures.sigs[id] = sig
+	signatures.Unlock()
+
+	sig = dns.Copy(sig).(*dns.RRSIG)
+	sig.Hdr.Name = owner
+	return sig
+}
+
+// signatureID identifies the signature of rrset by key in the current
+// version of the zone. The records are sorted, as the signature does not
+// depend on their order, so the same records of different views or
+// geographic variants share it.
+func (z *zone) signatureID(rrset []dns.RR, key signingKey) [sha256.Size]byte {
+	records := make([]string, len(rrset))
+	for i, rr := range rrset {
+		records[i] = rr.String()
+	}
+	sort.Strings(records)
+	version := fmt.Sprintf("%s/%d\n%s\n", z.ID, z.Serial, key.dnskey)
+	return sha256.Sum256([]byte(version + strings.Join(records, "\n")))
+}
+
+// splitRRsets groups rrs by owner and type, giving every RRset the lowest
+// TTL among its members as DNSSEC requires a single TTL per RRset.
+func splitRRsets(rrs []dns.RR) [][]dns.RR {
+	var sets [][]dns.RR
+	index := make(map[string]int)
+	for _, rr := range rrs {
+		h := rr.Header()
+		key := strings.ToLower(h.Name) + "/" + dns.TypeToString[h.Rrtype]
+		i, ok := index[key]
+		if !ok {
+			i = len(sets)
+			index[key] = i
+			sets = append(sets, nil)
+		}
+		sets[i] = append(sets[i], rr)
+	}
+
+	for _, set := range sets {
+		ttl := set[0].Header().Ttl
+		for _, rr := range set {
+			ttl = min(ttl, rr.Header().Ttl)
+		}
+		for _, rr := range set {
+			rr.Header().Ttl = ttl
+		}
+	}
+	return sets
+}
+
+// nsecChain lists the owner names that get an NSEC record in canonical
+// order: every node holding records that the zone is authoritative for,
+// plus the delegation points themselves. Empty non-terminals and glue below
+// a cut are left out.
+func (z *zone) nsecChain() []string {
+	if z.chain != nil {
+		return z.chain
+	}
+
+	var chain []string
+	for owner := range z.records {
+		if cut, ok := z.delegation(owner); ok && cut != owner {
+			continue
+		}
+		chain = append(chain, z.fqdn(owner))
+	}
+	if _, ok := z.records["@"]; !ok {
+		chain = append(chain, z.origin)
+	}
+	sort.Slice(chain, func(i, j int) bool { return canonicalLess(chain[i], chain[j]) })
+	z.chain = chain
+	return chain
+}
+
+// nsec returns the NSEC record that matches name if it is in the chain, or
+// otherwise covers it, i.e. the one owned by the closest preceding name.
+func (z *zone) nsec(name string) *dns.NSEC {
+	chain := z.nsecChain()
+	i := sort.Search(len(chain), func(i int) bool { return canonicalLess(name, chain[i]) }) - 1
+	if i < 0 {
+		// Nothing sorts before the apex inside the zone
+		i = 0
+	}
+	owner := chain[i]
+	next := chain[(i+1)%len(chain)]
+
+	ttl := uint32(3600)
+	if soa := z.negativeSOA(); soa != nil {
+		ttl = soa.Header().Ttl
+	}
+	return &dns.NSEC{
+		Hdr:        dns.RR_Header{Name: owner, Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: ttl},
+		NextDomain: next,
+		TypeBitMap: z.typeBitmap(relativeName(normalizeName(owner), normalizeName(z.origin))),
+	}
+}
+
+// typeBitmap lists the types present at owner for its NSEC record.
+func (z *zone) typeBitmap(owner string) []uint16 {
+	present := map[uint16]bool{dns.TypeNSEC: true, dns.TypeRRSIG: true}
+	if owner == "@" {
+		present[dns.TypeDNSKEY] = true
+		if len(z.keys.cds) > 0 {
+			present[dns.TypeCDS] = true
+			present[dns.TypeCDNSKEY] = true
+		}
+	}
+	if cut, ok := z.delegation(owner); ok && cut == owner {
+		// Only the NS set at a delegation point belongs to this zone
+		present[dns.TypeNS] = true
+	} else {
+		for _, record := range z.records[owner] {
+			if t, ok := dns.StringToType[record.Type]; ok {
+				present[t] = true
+			}
+		}
+	}
+
+	types := make([]uint16, 0, len(present))
+	for t := range present {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+// denial returns the signed NSEC records proving the non-existence claims
+// about names, each fully qualified, without duplicates. It returns nothing
+// when do is false or the zone is unsigned.
+func (z *zone) denial(do bool, names ...string) []dns.RR {
+	if !do || z.keys == nil {
+		return nil
+	}
+
+	var nsecs []dns.RR
+	seen := make(map[string]bool)
+	for _, name := range names {
+		nsec := z.nsec(name)
+		if seen[nsec.Hdr.Name] {
+			continue
+		}
+		seen[nsec.Hdr.Name] = true
+		nsecs = append(nsecs, nsec)
+	}
+	return z.sign(nsecs, do, "")
+}
+
+// canonicalLess orders domain names as RFC 4034 section 6.1 describes:
+// label by label from the root, comparing lowercased labels as byte strings.
+func canonicalLess(a, b string) bool {
+	la := dns.SplitDomainName(strings.ToLower(a))
+	lb := dns.SplitDomainName(strings.ToLower(b))
+	for i := 1; i <= len(la) && i <= len(lb); i++ {
+		x, y := la[len(la)-i], lb[len(lb)-i]
+		if x != y {
+			return x < y
+		}
+	}
+	return len(la) < len(lb)
+}
+
+// dnssecOK reports whether the client set the DO bit.
+func dnssecOK(r *dns.Msg) bool {
+	opt := r.IsEdns0()
+	return opt != nil && opt.Do()
+}
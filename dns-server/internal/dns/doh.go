@@ -0,0 +1,168 @@
+package dns
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// dohContentType is the media type of DNS messages over HTTPS.
+const dohContentType = "application/dns-message"
+
+// ServeHTTP answers DNS-over-HTTPS queries (RFC 8484), sent either as the
+// base64url encoded dns parameter of a GET or as the body of a POST. They
+// are answered like queries over TCP, and the response may be cached for
+// the lowest TTL it contains.
+func (s *DNSServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var buf []byte
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		buf, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(r.URL.Query().Get("dns"), "="))
+		if err != nil || len(buf) == 0 {
+			http.Error(w, "Missing or invalid dns parameter", http.StatusBadRequest)
+			return
+		}
+	case http.MethodPost:
+		if r.Header.Get("Content-Type") != dohContentType {
+			http.Error(w, "Content-Type must be "+dohContentType, http.StatusUnsupportedMediaType)
+			return
+		}
+		buf, err = io.ReadAll(io.LimitReader(r.Body, dns.MaxMsgSize+1))
+		if err != nil || len(buf) > dns.MaxMsgSize {
+			http.Error(w, "Invalid DNS message", http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	req := new(dns.Msg)
+	if err := req.Unpack(buf); err != nil {
+		http.Error(w, "Invalid DNS message", http.StatusBadRequest)
+		return
+	}
+
+	dw := &dohWriter{remote: httpRemoteAddr(r)}
+	if tsig := req.IsTsig(); tsig != nil {
+		dw.tsigStatus = dns.TsigVerifyWithProvider(buf, tsigProvider{s}, "", false)
+		dw.tsigRequestMAC = tsig.MAC
+		dw.tsigProvider = tsigProvider{s}
+	}
+
+	switch {
+	case req.Opcode != dns.OpcodeQuery:
+		// NOTIFY and UPDATE belong on the DNS ports
+		m := new(dns.Msg)
+		m.SetRcode(req, dns.RcodeNotImplemented)
+		dw.WriteMsg(m)
+	case len(req.Question) == 1 && (req.Question[0].Qtype == dns.TypeAXFR || req.Question[0].Qtype == dns.TypeIXFR):
+		// A zone transfer does not fit in one HTTP response
+		m := new(dns.Msg)
+		m.SetRcode(req, dns.RcodeRefused)
+		dw.WriteMsg(m)
+	default:
+		s.handleDNSRequest(dw, req)
+	}
+	if dw.msg == nil {
+		http.Error(w, "No response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", dohContentType)
+	w.Header().Set("Cache-Control", dohCacheControl(dw.reply))
+	if _, err := w.Write(dw.msg); err != nil {
+		log.Printf("Failed to write DoH response: %v", err)
+	}
+}
+
+// dohCacheControl derives the Cache-Control header of a DoH response from
+// the lowest TTL among its records (RFC 8484 section 5.1). Responses that
+// hold no records or report a failure are not cached.
+func dohCacheControl(m *dns.Msg) string {
+	if m.Rcode != dns.RcodeSuccess && m.Rcode != dns.RcodeNameError {
+		return "no-store"
+	}
+
+	ttl := -1
+	for _, section := range [][]dns.RR{m.Answer, m.Ns, m.Extra} {
+		for _, rr := range section {
+			switch rr.Header().Rrtype {
+			case dns.TypeOPT, dns.TypeTSIG:
+				continue
+			}
+			if ttl < 0 || int(rr.Header().Ttl) < ttl {
+				ttl = int(rr.Header().Ttl)
+			}
+		}
+	}
+	if ttl < 0 {
+		return "no-store"
+	}
+	return fmt.Sprintf("max-age=%d", ttl)
+}
+
+// httpRemoteAddr returns the address of the HTTP client as a TCP address,
+// so the query is treated like one over a stream transport.
+func httpRemoteAddr(r *http.Request) net.Addr {
+	host, port, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr := &net.TCPAddr{IP: net.ParseIP(host)}
+	addr.Port, _ = strconv.Atoi(port)
+	return addr
+}
+
+// dohWriter collects the response to a DoH query.
+type dohWriter struct {
+	remote net.Addr
+	// reply and msg are the response and its wire format
+	reply *dns.Msg
+	msg   []byte
+
+	tsigProvider   dns.TsigProvider
+	tsigStatus     error
+	tsigRequestMAC string
+}
+
+func (w *dohWriter) LocalAddr() net.Addr  { return &net.TCPAddr{} }
+func (w *dohWriter) RemoteAddr() net.Addr { return w.remote }
+
+func (w *dohWriter) WriteMsg(m *dns.Msg) error {
+	var buf []byte
+	var err error
+	if w.tsigProvider != nil && m.IsTsig() != nil {
+		buf, _, err = dns.TsigGenerateWithProvider(m, w.tsigProvider, w.tsigRequestMAC, false)
+	} else {
+		buf, err = m.Pack()
+	}
+	if err != nil {
+		return err
+	}
+	w.reply, w.msg = m, buf
+	return nil
+}
+
+func (w *dohWriter) Write(b []byte) (int, error) {
+	m := new(dns.Msg)
+	if err := m.Unpack(b); err != nil {
+		return 0, err
+	}
+	w.reply, w.msg = m, append([]byte(nil), b...)
+	return len(b), nil
+}
+
+func (w *dohWriter) Close() error        { return nil }
+func (w *dohWriter) TsigStatus() error   { return w.tsigStatus }
+func (w *dohWriter) TsigTimersOnly(bool) {}
+func (w *dohWriter) Hijack()             {}
@@ -0,0 +1,51 @@
+package dns
+
+import (
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// defaultEDNSBufferSize is the UDP payload size we advertise, the value
+// recommended by DNS Flag Day 2020 to avoid IP fragmentation.
+const defaultEDNSBufferSize = 1232
+
+// setupEDNS echoes the client's OPT record into m with our own buffer size.
+// It reports false if the client used an EDNS version we do not speak, in
+// which case m has already been turned into a BADVERS response.
+func (s *DNSServer) setupEDNS(r, m *dns.Msg) bool {
+	opt := r.IsEdns0()
+	if opt == nil {
+		return true
+	}
+
+	m.SetEdns0(s.ednsBufferSize, opt.Do())
+	if opt.Version() != 0 {
+		m.Rcode = dns.RcodeBadVers
+		return false
+	}
+	return true
+}
+
+// maxResponseSize returns how large a response to r may be on the transport
+// it arrived on. Stream transports are only bound by the message format,
+// UDP by the client's advertised EDNS buffer (512 bytes without EDNS)
+// capped by our own.
+func (s *DNSServer) maxResponseSize(w dns.ResponseWriter, r *dns.Msg) int {
+	if _, ok := w.RemoteAddr().(*net.UDPAddr); !ok {
+		return dns.MaxMsgSize
+	}
+
+	opt := r.IsEdns0()
+	if opt == nil {
+		return dns.MinMsgSize
+	}
+	size := int(opt.UDPSize())
+	if size > int(s.ednsBufferSize) {
+		size = int(s.ednsBufferSize)
+	}
+	if size < dns.MinMsgSize {
+		size = dns.MinMsgSize
+	}
+	return size
+}
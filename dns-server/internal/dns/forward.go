@@ -0,0 +1,266 @@
+package dns
+
+import (
+	"dns-server/internal/services"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Policies for questions outside the zones we host.
+const (
+	// outOfZoneRefuse answers REFUSED, as an authoritative-only server should
+	outOfZoneRefuse = "refuse"
+	// outOfZoneForward resolves the question through the upstream resolvers
+	// for clients on the forwarding ACL
+	outOfZoneForward = "forward"
+)
+
+const (
+	// forwardTimeout bounds each attempt at an upstream resolver
+	forwardTimeout = 2 * time.Second
+	// forwardCacheSize is how many responses the forwarding cache holds
+	forwardCacheSize = 10000
+	// forwardMaxTTL caps how long a forwarded response is cached
+	forwardMaxTTL = 24 * time.Hour
+)
+
+// outOfZonePolicies reads the out-of-zone policy of every listener:
+// DNS_OUT_OF_ZONE sets it for all of them and DNS_OUT_OF_ZONE_UDP, _TCP,
+// _TLS and _HTTPS override it per transport, e.g. to forward only for DoT
+// clients.
+func outOfZonePolicies() map[string]string {
+	policy := func(v, fallback string) string {
+		switch v = strings.ToLower(strings.TrimSpace(v)); v {
+		case outOfZoneRefuse, outOfZoneForward:
+			return v
+		case "":
+			return fallback
+		}
+		log.Printf("Unknown out-of-zone policy %q, refusing", v)
+		return outOfZoneRefuse
+	}
+
+	fallback := policy(os.Getenv("DNS_OUT_OF_ZONE"), outOfZoneRefuse)
+	policies := make(map[string]string)
+	for _, transport := range []string{"udp", "tcp", "tls", "https"} {
+		policies[transport] = policy(os.Getenv("DNS_OUT_OF_ZONE_"+strings.ToUpper(transport)), fallback)
+	}
+	return policies
+}
+
+// transport names the listener w belongs to.
+func transport(w dns.ResponseWriter) string {
+	switch w := w.(type) {
+	case *dohWriter:
+		return "https"
+	case dns.ConnectionStater:
+		if w.ConnectionState() != nil {
+			return "tls"
+		}
+	}
+	if _, ok := w.RemoteAddr().(*net.UDPAddr); ok {
+		return "udp"
+	}
+	return "tcp"
+}
+
+// forwarder resolves questions outside our zones through upstream
+// resolvers and caches their responses.
+type forwarder struct {
+	// upstreams are tried in order until one answers
+	upstreams []string
+	// allowed lists the client networks we forward for
+	allowed []*net.IPNet
+
+	mu    sync.Mutex
+	cache map[string]*forwardEntry
+}
+
+// forwardEntry is a cached upstream response.
+type forwardEntry struct {
+	msg     *dns.Msg
+	stored  time.Time
+	expires time.Time
+}
+
+// newForwarder configures forwarding from DNS_FORWARDERS, a comma
+// separated list of upstream resolvers, and DNS_FORWARD_ALLOW, the client
+// networks allowed to use them. It returns nil when no upstream is set.
+func newForwarder() *forwarder {
+	var upstreams []string
+	for _, upstream := range strings.Split(os.Getenv("DNS_FORWARDERS"), ",") {
+		upstream = strings.TrimSpace(upstream)
+		if upstream == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(upstream); err != nil {
+			upstream = net.JoinHostPort(strings.Trim(upstream, "[]"), "53")
+		}
+		upstreams = append(upstreams, upstream)
+	}
+	if len(upstreams) == 0 {
+		return nil
+	}
+
+	return &forwarder{
+		upstreams: upstreams,
+		allowed:   services.ParseNetworks(os.Getenv("DNS_FORWARD_ALLOW")),
+		cache:     make(map[string]*forwardEntry),
+	}
+}
+
+// outOfZone fills m with the response to r, whose question is outside the
+// zones we host, following the policy of the listener r arrived on. It
+// returns the message to send, which is a forwarded response when r was
+// resolved upstream.
+func (s *DNSServer) outOfZone(w dns.ResponseWriter, r, m *dns.Msg) *dns.Msg {
+	m.Authoritative = false
+	if s.outOfZonePolicy[transport(w)] != outOfZoneForward || s.forwarder == nil ||
+		!r.RecursionDesired || !services.ContainsIP(s.forwarder.allowed, remoteIP(w.RemoteAddr())) {
+		m.Rcode = dns.RcodeRefused
+		return m
+	}
+
+	resp, err := s.forwarder.resolve(r.Question[0], dnssecOK(r))
+	if err != nil {
+		log.Printf("Failed to forward %s/%s: %v", r.Question[0].Name, dns.TypeToString[r.Question[0].Qtype], err)
+		m.RecursionAvailable = true
+		m.Rcode = dns.RcodeServerFailure
+		return m
+	}
+
+	// The upstream response goes out with our header and EDNS settings
+	rcode, ad := resp.Rcode, resp.AuthenticatedData
+	resp.MsgHdr = m.MsgHdr
+	resp.Rcode, resp.AuthenticatedData = rcode, ad
+	resp.Question = r.Question
+	resp.RecursionAvailable = true
+	resp.Extra = append(resp.Extra, m.Extra...)
+	return resp
+}
+
+// resolve answers q from the cache or an upstream resolver. The returned
+// message is the caller's to change, without OPT record.
+func (f *forwarder) resolve(q dns.Question, do bool) (*dns.Msg, error) {
+	key := strings.ToLower(q.Name) + "/" + dns.TypeToString[q.Qtype] + "/" + dns.ClassToString[q.Qclass]
+	if do {
+		key += "/do"
+	}
+	if m := f.cached(key); m != nil {
+		return m, nil
+	}
+
+	req := new(dns.Msg)
+	req.Question = []dns.Question{q}
+	req.RecursionDesired = true
+	req.SetEdns0(defaultEDNSBufferSize, do)
+
+	var resp *dns.Msg
+	var err error
+	for _, upstream := range f.upstreams {
+		if resp, err = f.exchange(req, upstream); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Extra = withoutOPT(resp.Extra)
+	f.store(key, resp)
+	return resp.Copy(), nil
+}
+
+// exchange sends req to upstream over UDP, retrying over TCP when the
+// response is truncated.
+func (f *forwarder) exchange(req *dns.Msg, upstream string) (*dns.Msg, error) {
+	c := &dns.Client{Net: "udp", Timeout: forwardTimeout}
+	resp, _, err := c.Exchange(req, upstream)
+	if err == nil && resp.Truncated {
+		c.Net = "tcp"
+		resp, _, err = c.Exchange(req, upstream)
+	}
+	return resp, err
+}
+
+// cached returns a copy of the cached response stored under key with its
+// TTLs reduced by the time it spent in the cache, or nil.
+func (f *forwarder) cached(key string) *dns.Msg {
+	f.mu.Lock()
+	e, ok := f.cache[key]
+	f.mu.Unlock()
+	if !ok || time.Now().After(e.expires) {
+		return nil
+	}
+
+	m := e.msg.Copy()
+	age := uint32(time.Since(e.stored).Seconds())
+	for _, section := range [][]dns.RR{m.Answer, m.Ns, m.Extra} {
+		for _, rr := range section {
+			rr.Header().Ttl -= min(age, rr.Header().Ttl)
+		}
+	}
+	return m
+}
+
+// store caches resp under key for the lowest TTL it carries. Negative
+// responses are kept no longer than their SOA's minimum (RFC 2308);
+// failures are not cached.
+func (f *forwarder) store(key string, resp *dns.Msg) {
+	if resp.Truncated || (resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError) {
+		return
+	}
+	ttl := forwardMaxTTL
+	for _, section := range [][]dns.RR{resp.Answer, resp.Ns, resp.Extra} {
+		for _, rr := range section {
+			ttl = min(ttl, time.Duration(rr.Header().Ttl)*time.Second)
+			if soa, ok := rr.(*dns.SOA); ok {
+				ttl = min(ttl, time.Duration(soa.Minttl)*time.Second)
+			}
+		}
+	}
+	if ttl <= 0 || len(resp.Answer)+len(resp.Ns) == 0 {
+		return
+	}
+
+	now := time.Now()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.cache) >= forwardCacheSize {
+		f.evict(now)
+	}
+	f.cache[key] = &forwardEntry{msg: resp.Copy(), stored: now, expires: now.Add(ttl)}
+}
+
+// evict makes room in the full cache: expired responses go first, and if
+// none have expired an arbitrary tenth of the cache.
+func (f *forwarder) evict(now time.Time) {
+	for key, e := range f.cache {
+		if now.After(e.expires) {
+			delete(f.cache, key)
+		}
+	}
+	for key := range f.cache {
+		if len(f.cache) < forwardCacheSize*9/10 {
+			break
+		}
+		delete(f.cache, key)
+	}
+}
+
+// withoutOPT drops the OPT record from an ADDITIONAL section.
+func withoutOPT(extra []dns.RR) []dns.RR {
+	out := extra[:0]
+	for _, rr := range extra {
+		if rr.Header().Rrtype != dns.TypeOPT {
+			out = append(out, rr)
+		}
+	}
+	return out
+}
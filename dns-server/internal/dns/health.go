@@ -0,0 +1,307 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"dns-server/internal/models"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/miekg/dns"
+)
+
+const (
+	// healthTick is how often due health checks are started
+	healthTick = time.Second
+	// healthReload is how often the health checks are read from the database
+	healthReload = 30 * time.Second
+	// healthHistory is how long probe results are kept
+	healthHistory = 24 * time.Hour
+	// healthBodyLimit bounds how much of an HTTP response body is searched
+	healthBodyLimit = 64 << 10
+)
+
+// healthDialer connects to health check targets, which must be public.
+var healthDialer = &net.Dialer{Control: dialPublicOnly}
+
+// healthError turns why a probe failed into the message stored and shown to
+// the record's owner, which leaves out what the target sent back.
+func healthError(err error) string {
+	var status statusError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, errTargetNotAllowed):
+		return errTargetNotAllowed.Error()
+	case errors.As(err, &status):
+		return status.Error()
+	case errors.Is(err, errBodyMismatch):
+		return errBodyMismatch.Error()
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timed out"
+	}
+	return "connection failed"
+}
+
+// statusError is an HTTP status other than the one a check expects.
+type statusError struct {
+	status, expected int
+}
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("status %d, expected %d", e.status, e.expected)
+}
+
+// errBodyMismatch is an HTTP response without the text a check expects.
+var errBodyMismatch = errors.New("response body does not contain the expected text")
+
+// healthChecker runs the health checks of records and tracks which of
+// their targets are down.
+type healthChecker struct {
+	mu     sync.Mutex
+	probes map[uuid.UUID]*healthProbe // by check ID
+
+	// down holds the IDs of the records whose target is unhealthy. It is
+	// read when answering, so it is kept apart from mu.
+	down sync.Map
+}
+
+// healthProbe is a health check together with its run state.
+type healthProbe struct {
+	check models.HealthCheck
+	// successes and failures count the latest results in a row
+	successes, failures int
+	next                time.Time
+	running             bool
+}
+
+func newHealthChecker() *healthChecker {
+	return &healthChecker{probes: make(map[uuid.UUID]*healthProbe)}
+}
+
+// isDown reports whether the target of record failed its health check.
+func (h *healthChecker) isDown(recordID uuid.UUID) bool {
+	_, down := h.down.Load(recordID)
+	return down
+}
+
+func (h *healthChecker) setDown(recordID uuid.UUID, down bool) {
+	if down {
+		h.down.Store(recordID, true)
+	} else {
+		h.down.Delete(recordID)
+	}
+}
+
+// healthyRecords returns the records of type qtype whose target is not
+// down.
+func (s *DNSServer) healthyRecords(records []models.Record, qtype uint16) []models.Record {
+	var out []models.Record
+	for _, record := range records {
+		if dns.StringToType[record.Type] == qtype && !s.health.isDown(record.ID) {
+			out = append(out, record)
+		}
+	}
+	return out
+}
+
+// runHealthChecks probes every health check on its interval, picking up
+// checks added, changed or removed through the API every healthReload.
+func (s *DNSServer) runHealthChecks() {
+	ticker := time.NewTicker(healthTick)
+	defer ticker.Stop()
+
+	var loaded time.Time
+	for now := range ticker.C {
+		if now.Sub(loaded) >= healthReload {
+			loaded = now
+			if err := s.loadHealthChecks(now); err != nil {
+				log.Printf("Failed to load health checks: %v", err)
+			}
+			if err := s.db.DeleteHealthCheckResultsBefore(now.Add(-healthHistory)); err != nil {
+				log.Printf("Failed to prune health check history: %v", err)
+			}
+		}
+		s.startHealthChecks(now)
+	}
+}
+
+// loadHealthChecks reads the health checks from the database. Checks that
+// are already running keep their state, which is ahead of the stored one.
+func (s *DNSServer) loadHealthChecks(now time.Time) error {
+	checks, err := s.db.GetHealthChecks()
+	if err != nil {
+		return err
+	}
+
+	h := s.health
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	seen := make(map[uuid.UUID]bool)
+	for _, check := range checks {
+		seen[check.ID] = true
+		p, ok := h.probes[check.ID]
+		if !ok {
+			h.probes[check.ID] = &healthProbe{check: check, next: now}
+			h.setDown(check.RecordID, !check.Healthy)
+			continue
+		}
+		check.Healthy, check.LastCheckedAt, check.LastError = p.check.Healthy, p.check.LastCheckedAt, p.check.LastError
+		p.check = check
+	}
+	for id, p := range h.probes {
+		if !seen[id] {
+			delete(h.probes, id)
+			h.setDown(p.check.RecordID, false)
+		}
+	}
+	return nil
+}
+
+// startHealthChecks starts the checks that are due and not still running.
+func (s *DNSServer) startHealthChecks(now time.Time) {
+	h := s.health
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, p := range h.probes {
+		if !p.running && !now.Before(p.next) {
+			p.running = true
+			go s.runHealthCheck(p)
+		}
+	}
+}
+
+// runHealthCheck probes the target of one check, moves it between healthy
+// and unhealthy once rise or fall results in a row agree, and stores the
+// result.
+func (s *DNSServer) runHealthCheck(p *healthProbe) {
+	h := s.health
+	h.mu.Lock()
+	check := p.check
+	h.mu.Unlock()
+
+	start := time.Now()
+	err := probe(check)
+	result := &models.HealthCheckResult{
+		CheckID:   check.ID,
+		Success:   err == nil,
+		Duration:  int(time.Since(start).Milliseconds()),
+		CheckedAt: start,
+	}
+	if err != nil {
+		msg := healthError(err)
+		result.Error = &msg
+	}
+
+	h.mu.Lock()
+	if err == nil {
+		p.successes, p.failures = p.successes+1, 0
+	} else {
+		p.successes, p.failures = 0, p.failures+1
+	}
+	switch {
+	case p.check.Healthy && p.failures >= p.check.Fall:
+		p.check.Healthy = false
+		log.Printf("Health check %s: %s is down: %v", check.ID, check.Target, err)
+	case !p.check.Healthy && p.successes >= p.check.Rise:
+		p.check.Healthy = true
+		log.Printf("Health check %s: %s is up again", check.ID, check.Target)
+	}
+	if result.Error != nil {
+		p.check.LastError = result.Error
+	}
+	p.check.LastCheckedAt = &start
+	result.Healthy = p.check.Healthy
+	lastError := p.check.LastError
+	p.running = false
+	p.next = start.Add(time.Duration(p.check.Interval) * time.Second)
+	if h.probes[check.ID] == p {
+		// A check removed meanwhile no longer decides anything
+		h.setDown(check.RecordID, !p.check.Healthy)
+	}
+	h.mu.Unlock()
+
+	if err := s.db.AddHealthCheckResult(result, lastError); err != nil {
+		log.Printf("Failed to store health check result %s: %v", check.ID, err)
+	}
+}
+
+// probe runs check once against its target, returning why it failed.
+func probe(check models.HealthCheck) error {
+	timeout := time.Duration(check.Timeout) * time.Second
+	addr := net.JoinHostPort(strings.TrimSuffix(check.Target, "."), strconv.Itoa(check.Port))
+
+	switch check.Type {
+	case "tcp":
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		conn, err := healthDialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case "http", "https":
+		return probeHTTP(check, addr, timeout)
+	}
+	return fmt.Errorf("unknown health check type %q", check.Type)
+}
+
+// probeHTTP fetches the check's path from addr and compares the status
+// and body with what the check expects. Redirects are not followed, and
+// HTTPS certificates are verified for the check's host, or the target
+// when it has none.
+func probeHTTP(check models.HealthCheck, addr string, timeout time.Duration) error {
+	req, err := http.NewRequest(http.MethodGet, check.Type+"://"+addr+check.Path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "dns-server health check")
+
+	transport := &http.Transport{
+		DialContext:       healthDialer.DialContext,
+		DisableKeepAlives: true,
+		TLSClientConfig:   &tls.Config{},
+	}
+	if check.Host != nil && *check.Host != "" {
+		req.Host = *check.Host
+		transport.TLSClientConfig.ServerName = *check.Host
+	}
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != check.ExpectedStatus {
+		return statusError{resp.StatusCode, check.ExpectedStatus}
+	}
+	if check.ExpectedBody != nil && *check.ExpectedBody != "" {
+		body, err := io.ReadAll(io.LimitReader(resp.Body, healthBodyLimit))
+		if err != nil {
+			return err
+		}
+		if !bytes.Contains(body, []byte(*check.ExpectedBody)) {
+			return errBodyMismatch
+		}
+	}
+	return nil
+}
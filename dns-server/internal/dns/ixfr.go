@@ -0,0 +1,107 @@
+package dns
+
+import (
+	"dns-server/internal/models"
+	"log"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// ixfr returns the records answering an IXFR from serial (RFC 1995). A
+// client that is up to date gets the current SOA alone. Otherwise the
+// journaled changes since serial are sent as one difference sequence per
+// serial, falling back to a full transfer when the journal no longer holds
+// the client's version or a change since cannot be sent as a difference. Signed
+// zones are always sent in full, since every transfer carries fresh online
+// signatures.
+func (s *DNSServer) ixfr(z *zone, serial uint32) ([]dns.RR, error) {
+	soa := z.soa()
+	if soa == nil || !serialLess(serial, soa.(*dns.SOA).Serial) {
+		return []dns.RR{soa}, nil
+	}
+	if z.keys != nil {
+		return z.axfr()
+	}
+
+	changes, err := s.db.GetZoneChangesSince(z.ID.String(), int64(serial))
+	if err != nil {
+		return nil, err
+	}
+	if len(changes) == 0 || uint32(changes[len(changes)-1].Serial) != uint32(z.Serial) {
+		log.Printf("Journal of %s does not cover serial %d, sending AXFR", z.DomainName, serial)
+		return z.axfr()
+	}
+
+	rrs := []dns.RR{soa}
+	// from is the serial each sequence starts at: the journal picks up
+	// right after the client's version, so the first one starts at the
+	// client's serial and each further one at the version before it,
+	// whatever the gap between them
+	from := serial
+	for i := 0; i < len(changes); {
+		// One difference sequence: the SOA before and after the change,
+		// each followed by the records deleted and added by it
+		version := uint32(changes[i].Serial)
+		if !serialLess(from, version) {
+			log.Printf("Journal of %s goes from serial %d back to %d, sending AXFR", z.DomainName, from, version)
+			return z.axfr()
+		}
+		var deleted, added []dns.RR
+		for ; i < len(changes) && uint32(changes[i].Serial) == version; i++ {
+			if changes[i].Action == "reset" {
+				log.Printf("Serial %d of %s cannot be sent as a difference, sending AXFR", version, z.DomainName)
+				return z.axfr()
+			}
+			rr, err := z.changeToRR(&changes[i])
+			if err != nil {
+				log.Printf("Skipping journal entry %d of %s: %v", changes[i].ID, z.DomainName, err)
+				continue
+			}
+			if rr == nil {
+				continue
+			}
+			if changes[i].Action == "delete" {
+				deleted = append(deleted, rr)
+			} else {
+				added = append(added, rr)
+			}
+		}
+
+		rrs = append(rrs, soaWithSerial(soa, from))
+		rrs = append(rrs, deleted...)
+		rrs = append(rrs, soaWithSerial(soa, version))
+		rrs = append(rrs, added...)
+		from = version
+	}
+	return append(rrs, dns.Copy(soa)), nil
+}
+
+// changeToRR rebuilds the record of a journal entry. SOA changes yield nil
+// as the difference sequences carry the SOA themselves.
+func (z *zone) changeToRR(change *models.ZoneChange) (dns.RR, error) {
+	if change.Type == "SOA" {
+		return nil, nil
+	}
+	record := models.Record{
+		Type:     change.Type,
+		Name:     change.Name,
+		Value:    change.Value,
+		TTL:      change.TTL,
+		Priority: change.Priority,
+	}
+	return z.recordToRR(z.fqdn(strings.ToLower(change.Name)), record)
+}
+
+// soaWithSerial returns a copy of soa carrying serial.
+func soaWithSerial(soa dns.RR, serial uint32) dns.RR {
+	rr := dns.Copy(soa).(*dns.SOA)
+	rr.Serial = serial
+	return rr
+}
+
+// serialLess compares zone serials using RFC 1982 serial number arithmetic,
+// so the comparison survives the serial wrapping around.
+func serialLess(a, b uint32) bool {
+	return a != b && int32(b-a) > 0
+}
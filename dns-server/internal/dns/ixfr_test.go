@@ -0,0 +1,198 @@
+package dns
+
+import (
+	"dns-server/internal/database"
+	"dns-server/internal/models"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/miekg/dns"
+)
+
+// journalDB serves a zone journal the way GetZoneChangesSince reads it from
+// the database: the entries after the last one of the version asked for.
+type journalDB struct {
+	database.Service
+	changes []models.ZoneChange
+}
+
+func (db *journalDB) GetZoneChangesSince(domainID string, serial int64) ([]models.ZoneChange, error) {
+	for i := len(db.changes) - 1; i >= 0; i-- {
+		if db.changes[i].Serial == serial {
+			return db.changes[i+1:], nil
+		}
+	}
+	return nil, nil
+}
+
+// dateSerialZone is a zone whose serials follow DNS_SERIAL_SCHEME=date: it
+// was at 2026101704, changed www at 2026101705 and added mail at 2026101800.
+func dateSerialZone() (*zone, *journalDB) {
+	domain := &models.Domain{ID: uuid.New(), DomainName: "example.com", Serial: 2026101800}
+	record := func(typ, name, value string) models.Record {
+		return models.Record{ID: uuid.New(), DomainID: domain.ID, Type: typ, Name: name, Value: value, TTL: 300}
+	}
+	records := []models.Record{
+		record("SOA", "@", "ns1.example.com. admin.example.com. 1 7200 3600 1209600 60"),
+		record("NS", "@", "ns1.example.com."),
+		record("A", "www", "192.0.2.2"),
+		record("A", "mail", "192.0.2.3"),
+	}
+	db := &journalDB{changes: []models.ZoneChange{
+		{ID: 1, Serial: 2026101704, Action: "serial", Type: "SOA", Name: "@"},
+		{ID: 2, Serial: 2026101705, Action: "serial", Type: "SOA", Name: "@"},
+		{ID: 3, Serial: 2026101705, Action: "delete", Type: "A", Name: "www", Value: "192.0.2.1", TTL: 300},
+		{ID: 4, Serial: 2026101705, Action: "add", Type: "A", Name: "www", Value: "192.0.2.2", TTL: 300},
+		{ID: 5, Serial: 2026101800, Action: "serial", Type: "SOA", Name: "@"},
+		{ID: 6, Serial: 2026101800, Action: "add", Type: "A", Name: "mail", Value: "192.0.2.3", TTL: 300},
+	}}
+	return newZone(domain, records, nil), db
+}
+
+func mustRR(t *testing.T, s string) dns.RR {
+	t.Helper()
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rr
+}
+
+func TestIXFRDateSerials(t *testing.T) {
+	z, db := dateSerialZone()
+	s := &DNSServer{db: db}
+
+	rrs, err := s.ixfr(z, 2026101704)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var serials []uint32
+	for _, rr := range rrs {
+		if soa, ok := rr.(*dns.SOA); ok {
+			serials = append(serials, soa.Serial)
+		}
+	}
+	want := []uint32{2026101800, 2026101704, 2026101705, 2026101705, 2026101800, 2026101800}
+	if len(serials) != len(want) {
+		t.Fatalf("SOA serials %v, want %v", serials, want)
+	}
+	for i := range want {
+		if serials[i] != want[i] {
+			t.Fatalf("SOA serials %v, want %v", serials, want)
+		}
+	}
+}
+
+func TestIXFRUpToDate(t *testing.T) {
+	z, db := dateSerialZone()
+	s := &DNSServer{db: db}
+
+	rrs, err := s.ixfr(z, 2026101800)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rrs) != 1 || rrs[0].(*dns.SOA).Serial != 2026101800 {
+		t.Fatalf("got %v, want the current SOA alone", rrs)
+	}
+}
+
+func TestApplyTransferIncremental(t *testing.T) {
+	z, db := dateSerialZone()
+	s := &DNSServer{db: db}
+	rrs, err := s.ixfr(z, 2026101704)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// What a secondary at 2026101704 holds
+	have := make(map[string]dns.RR)
+	for _, rr := range []dns.RR{
+		mustRR(t, "example.com. 300 IN SOA ns1.example.com. admin.example.com. 2026101704 7200 3600 1209600 60"),
+		mustRR(t, "example.com. 300 IN NS ns1.example.com."),
+		mustRR(t, "www.example.com. 300 IN A 192.0.2.1"),
+	} {
+		have[rrKey(rr)] = rr
+	}
+
+	got, serial, err := applyTransfer(have, rrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serial != 2026101800 {
+		t.Errorf("serial %d, want 2026101800", serial)
+	}
+	for _, s := range []string{
+		"example.com. 300 IN NS ns1.example.com.",
+		"www.example.com. 300 IN A 192.0.2.2",
+		"mail.example.com. 300 IN A 192.0.2.3",
+	} {
+		if _, ok := got[rrKey(mustRR(t, s))]; !ok {
+			t.Errorf("missing %s", s)
+		}
+	}
+	if _, ok := got[rrKey(mustRR(t, "www.example.com. 300 IN A 192.0.2.1"))]; ok {
+		t.Error("deleted record still present")
+	}
+	if len(got) != 4 {
+		t.Errorf("got %d records, want 4", len(got))
+	}
+}
+
+func TestApplyTransferFull(t *testing.T) {
+	have := map[string]dns.RR{}
+	stale := mustRR(t, "old.example.com. 300 IN A 192.0.2.9")
+	have[rrKey(stale)] = stale
+
+	soa := mustRR(t, "example.com. 300 IN SOA ns1.example.com. admin.example.com. 7 7200 3600 1209600 60")
+	rrs := []dns.RR{soa, mustRR(t, "www.example.com. 300 IN A 192.0.2.1"), soa}
+
+	got, serial, err := applyTransfer(have, rrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serial != 7 || len(got) != 2 {
+		t.Errorf("serial %d with %d records, want 7 with 2", serial, len(got))
+	}
+	if _, ok := got[rrKey(stale)]; ok {
+		t.Error("a full transfer kept records it did not carry")
+	}
+}
+
+func TestApplyTransferRejectsMissingSOA(t *testing.T) {
+	if _, _, err := applyTransfer(nil, nil); err == nil {
+		t.Error("empty transfer accepted")
+	}
+	if _, _, err := applyTransfer(nil, []dns.RR{mustRR(t, "www.example.com. 300 IN A 192.0.2.1")}); err == nil {
+		t.Error("transfer without SOA accepted")
+	}
+}
+
+func TestIXFRResetSendsAXFR(t *testing.T) {
+	z, db := dateSerialZone()
+	db.changes[4].Action = "reset"
+	s := &DNSServer{db: db}
+
+	rrs, err := s.ixfr(z, 2026101704)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rrs) < 2 || rrs[1].Header().Rrtype == dns.TypeSOA {
+		t.Fatalf("got a difference across a reset: %v", rrs)
+	}
+}
+
+func TestIXFRJournalMissingVersion(t *testing.T) {
+	z, db := dateSerialZone()
+	s := &DNSServer{db: db}
+
+	// 2026101790 was never a version of the zone, so there is no difference
+	// to send from it
+	rrs, err := s.ixfr(z, 2026101790)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rrs) < 2 || rrs[1].Header().Rrtype == dns.TypeSOA {
+		t.Fatalf("got a difference from an unknown serial: %v", rrs)
+	}
+}
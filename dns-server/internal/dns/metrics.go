@@ -0,0 +1,15 @@
+package dns
+
+import "expvar"
+
+// Counters published through expvar, served by the API at /debug/vars.
+var (
+	// staleAnswers counts zone lookups answered from stale data
+	staleAnswers = expvar.NewInt("dns_stale_answers")
+	// servingStale is 1 while the database is down and stale data is served
+	servingStale = expvar.NewInt("dns_serving_stale")
+	// rrlDropped and rrlSlipped count UDP responses over the rate limit
+	// that were dropped or sent truncated
+	rrlDropped = expvar.NewInt("dns_rrl_dropped")
+	rrlSlipped = expvar.NewInt("dns_rrl_slipped")
+)
@@ -0,0 +1,131 @@
+package dns
+
+import (
+	"dns-server/internal/models"
+	"log"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// notifyInterval is how often zones are checked for serial changes
+	notifyInterval = 5 * time.Second
+	// notifyAttempts bounds how often a NOTIFY is sent to a secondary that
+	// does not acknowledge it
+	notifyAttempts = 5
+	// notifyRetryDelay is the wait before the first retry, doubled after
+	// every further attempt
+	notifyRetryDelay = 2 * time.Second
+	// journalRetention is how long zone journal entries are kept for IXFR;
+	// secondaries further behind get a full transfer
+	journalRetention = 7 * 24 * time.Hour
+	// journalPruneInterval is how often expired journal entries are removed
+	journalPruneInterval = time.Hour
+)
+
+// runNotifier sends NOTIFY messages (RFC 1996) to the secondaries of every
+// zone whose serial changed, so they refresh without waiting for the SOA
+// refresh timer. It also prunes the zone journal, which only serves the
+// secondaries it notifies.
+func (s *DNSServer) runNotifier() {
+	ticker := time.NewTicker(notifyInterval)
+	defer ticker.Stop()
+
+	var pruned time.Time
+	for now := range ticker.C {
+		if now.Sub(pruned) >= journalPruneInterval {
+			pruned = now
+			if n, err := s.db.DeleteZoneChangesBefore(now.Add(-journalRetention)); err != nil {
+				log.Printf("Failed to prune zone journal: %v", err)
+			} else if n > 0 {
+				log.Printf("Pruned %d zone journal entries", n)
+			}
+		}
+
+		domains, err := s.db.GetDomainsPendingNotify()
+		if err != nil {
+			log.Printf("Failed to fetch zones to notify: %v", err)
+			continue
+		}
+		for i := range domains {
+			// Record the serial first so a slow secondary does not get the
+			// same change announced on every tick
+			if err := s.db.SetDomainNotifiedSerial(domains[i].ID.String(), domains[i].Serial); err != nil {
+				log.Printf("Failed to record NOTIFY of %s: %v", domains[i].DomainName, err)
+				continue
+			}
+			go s.notifySecondaries(&domains[i])
+		}
+	}
+}
+
+// notifySecondaries announces the current serial of domain to each of its
+// secondaries.
+func (s *DNSServer) notifySecondaries(domain *models.Domain) {
+	secondaries, err := s.db.GetSecondariesByDomain(domain.ID.String())
+	if err != nil {
+		log.Printf("Failed to fetch secondaries of %s: %v", domain.DomainName, err)
+		return
+	}
+	if len(secondaries) == 0 {
+		return
+	}
+
+	z, err := s.loadZone(domain)
+	if err != nil {
+		log.Printf("Failed to load zone %s: %v", domain.DomainName, err)
+		return
+	}
+	soa := z.soa()
+	for i := range secondaries {
+		go s.notify(domain, soa, &secondaries[i])
+	}
+}
+
+// notify sends a NOTIFY for domain to secondary over UDP, retrying with
+// backoff until it is acknowledged or notifyAttempts is reached. soa, if
+// not nil, is included as a hint of the new serial.
+func (s *DNSServer) notify(domain *models.Domain, soa dns.RR, secondary *models.Secondary) {
+	addr := net.JoinHostPort(secondary.Address, "53")
+
+	m := new(dns.Msg)
+	m.SetNotify(dns.Fqdn(domain.DomainName))
+	m.Authoritative = true
+	if soa != nil {
+		m.Answer = []dns.RR{soa}
+	}
+
+	c := &dns.Client{TsigProvider: tsigProvider{s}}
+	if secondary.TSIGKeyName != nil {
+		key, err := s.tsigKey(*secondary.TSIGKeyName)
+		if err != nil {
+			log.Printf("NOTIFY of %s to %s: TSIG key %s: %v", domain.DomainName, addr, *secondary.TSIGKeyName, err)
+			return
+		}
+		m.SetTsig(dns.Fqdn(key.Name), tsigAlgorithm(key), 300, time.Now().Unix())
+	}
+
+	delay := notifyRetryDelay
+	for attempt := 1; ; attempt++ {
+		r, _, err := c.Exchange(m, addr)
+		switch {
+		case err != nil:
+			log.Printf("NOTIFY of %s serial %d to %s failed (attempt %d): %v", domain.DomainName, domain.Serial, addr, attempt, err)
+		case r.Opcode != dns.OpcodeNotify || r.Rcode != dns.RcodeSuccess:
+			log.Printf("NOTIFY of %s serial %d to %s answered with %s", domain.DomainName, domain.Serial, addr, dns.RcodeToString[r.Rcode])
+			return
+		default:
+			log.Printf("NOTIFY of %s serial %d acknowledged by %s", domain.DomainName, domain.Serial, addr)
+			return
+		}
+
+		if attempt == notifyAttempts {
+			log.Printf("NOTIFY of %s serial %d to %s not acknowledged after %d attempts", domain.DomainName, domain.Serial, addr, attempt)
+			return
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
@@ -0,0 +1,101 @@
+package dns
+
+import (
+	"dns-server/internal/models"
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// recordToRR converts a stored record into a resource record owned by owner,
+// which must be fully qualified.
+func recordToRR(owner string, record models.Record) (dns.RR, error) {
+	switch record.Type {
+	case "A":
+		return dns.NewRR(fmt.Sprintf("%s %d IN A %s", owner, record.TTL, record.Value))
+	case "AAAA":
+		return dns.NewRR(fmt.Sprintf("%s %d IN AAAA %s", owner, record.TTL, record.Value))
+	case "CNAME":
+		return dns.NewRR(fmt.Sprintf("%s %d IN CNAME %s", owner, record.TTL, record.Value))
+	case "MX":
+		p := 10
+		if record.Priority != nil {
+			p = *record.Priority
+		}
+		return dns.NewRR(fmt.Sprintf("%s %d IN MX %d %s", owner, record.TTL, p, record.Value))
+	case "TXT":
+		// Long values such as DKIM keys are split into 255 byte strings
+		return &dns.TXT{
+			Hdr: dns.RR_Header{Name: owner, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: uint32(record.TTL)},
+			Txt: splitTXT(record.Value),
+		}, nil
+	case "NS":
+		return dns.NewRR(fmt.Sprintf("%s %d IN NS %s", owner, record.TTL, record.Value))
+	case "SRV":
+		p := 0
+		if record.Priority != nil {
+			p = *record.Priority
+		}
+		return dns.NewRR(fmt.Sprintf("%s %d IN SRV %d 0 0 %s", owner, record.TTL, p, record.Value))
+	case "CAA":
+		return dns.NewRR(fmt.Sprintf("%s %d IN CAA 0 issue \"%s\"", owner, record.TTL, record.Value))
+	case "SOA":
+		// <mname> <rname> <serial> <refresh> <retry> <expire> <minimum>
+		return dns.NewRR(fmt.Sprintf("%s %d IN SOA %s", owner, record.TTL, record.Value))
+	default:
+		return nil, fmt.Errorf("unsupported record type: %s", record.Type)
+	}
+}
+
+// rrToRecord is the inverse of recordToRR: it converts a resource record
+// into the type, value, TTL and priority of a stored record. Records the
+// records table cannot represent exactly are rejected.
+func rrToRecord(rr dns.RR) (models.Record, error) {
+	record := models.Record{
+		Type: dns.TypeToString[rr.Header().Rrtype],
+		TTL:  int(rr.Header().Ttl),
+	}
+	switch rr := rr.(type) {
+	case *dns.A:
+		record.Value = rr.A.String()
+	case *dns.AAAA:
+		record.Value = rr.AAAA.String()
+	case *dns.CNAME:
+		record.Value = rr.Target
+	case *dns.MX:
+		p := int(rr.Preference)
+		record.Priority = &p
+		record.Value = rr.Mx
+	case *dns.TXT:
+		record.Value = strings.Join(rr.Txt, "")
+	case *dns.NS:
+		record.Value = rr.Ns
+	case *dns.SRV:
+		if rr.Weight != 0 || rr.Port != 0 {
+			return record, fmt.Errorf("SRV records with a weight or port are not supported")
+		}
+		p := int(rr.Priority)
+		record.Priority = &p
+		record.Value = rr.Target
+	case *dns.CAA:
+		if rr.Flag != 0 || rr.Tag != "issue" {
+			return record, fmt.Errorf("only CAA issue records are supported")
+		}
+		record.Value = rr.Value
+	default:
+		return record, fmt.Errorf("unsupported record type: %s", record.Type)
+	}
+	return record, nil
+}
+
+// splitTXT breaks a TXT value into the character-strings of at most 255
+// bytes that the wire format allows.
+func splitTXT(value string) []string {
+	var parts []string
+	for len(value) > 255 {
+		parts = append(parts, value[:255])
+		value = value[255:]
+	}
+	return append(parts, value)
+}
@@ -0,0 +1,101 @@
+package dns
+
+import (
+	"dns-server/internal/models"
+	"dns-server/internal/services"
+	"log"
+	"time"
+)
+
+// rolloverInterval is how often scheduled key rollover steps are checked.
+const rolloverInterval = time.Minute
+
+// runRollovers advances DNSSEC key rollovers whose next step is due, and
+// bumps the serial of signed zones that have not changed in resignInterval
+// so their secondaries never serve expired signatures. The rollover steps
+// themselves are started from the API:
+//
+//   - ZSK pre-publish: publishing -> retiring -> completed. The new key is
+//     published, then signs while the old one stays published, then the old
+//     one is removed.
+//   - KSK double signature: waiting_ds -> removing -> completed. Both keys
+//     sign the DNSKEY RRset until the user reports the new DS at the parent,
+//     then the old key is removed once the old DS has expired.
+func (s *DNSServer) runRollovers() {
+	ticker := time.NewTicker(rolloverInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		if n, err := s.db.BumpSignedZoneSerials(now.Add(-resignInterval)); err != nil {
+			log.Printf("Failed to bump serials of signed zones: %v", err)
+		} else if n > 0 {
+			log.Printf("Bumped the serial of %d signed zones for their secondaries to re-sign", n)
+		}
+
+		rollovers, err := s.db.GetDueDNSSECRollovers(now)
+		if err != nil {
+			log.Printf("Failed to fetch due DNSSEC rollovers: %v", err)
+			continue
+		}
+		for i := range rollovers {
+			if err := s.advanceRollover(&rollovers[i]); err != nil {
+				log.Printf("Failed to advance DNSSEC rollover %s: %v", rollovers[i].ID, err)
+			}
+		}
+	}
+}
+
+// advanceRollover performs the next step of a due rollover.
+func (s *DNSServer) advanceRollover(rollover *models.DNSSECRollover) error {
+	now := time.Now()
+
+	switch rollover.Status {
+	case "publishing":
+		// The new ZSK is in every cached DNSKEY RRset, so it can sign
+		if err := s.db.UpdateDNSSECKeyState(rollover.NewKeyID.String(), services.KeyActive); err != nil {
+			return err
+		}
+		if err := s.db.UpdateDNSSECKeyState(rollover.OldKeyID.String(), services.KeyRetired); err != nil {
+			return err
+		}
+
+		// Keep the old key until its signatures have expired from caches
+		maxTTL, err := s.maxZoneTTL(rollover.DomainID.String())
+		if err != nil {
+			return err
+		}
+		next := now.Add(time.Duration(maxTTL)*time.Second + services.DNSSECPropagationMargin)
+		rollover.Status = "retiring"
+		rollover.NextActionAt = &next
+
+	case "retiring", "removing":
+		if err := s.db.UpdateDNSSECKeyState(rollover.OldKeyID.String(), services.KeyRemoved); err != nil {
+			return err
+		}
+		rollover.Status = "completed"
+		rollover.NextActionAt = nil
+
+	default:
+		// waiting_ds only moves on when the user confirms the new DS
+		return nil
+	}
+
+	rollover.UpdatedAt = now
+	log.Printf("DNSSEC %s rollover %s for domain %s is now %s", rollover.KeyType, rollover.ID, rollover.DomainID, rollover.Status)
+	return s.db.UpdateDNSSECRollover(rollover)
+}
+
+// maxZoneTTL returns the largest TTL of any record in the domain, which
+// bounds how long a signature over it may stay cached.
+func (s *DNSServer) maxZoneTTL(domainID string) (int, error) {
+	records, err := s.db.GetRecordsByDomain(domainID)
+	if err != nil {
+		return 0, err
+	}
+
+	maxTTL := services.DNSKEYTTL
+	for _, record := range records {
+		maxTTL = max(maxTTL, record.TTL)
+	}
+	return maxTTL, nil
+}
@@ -0,0 +1,226 @@
+package dns
+
+import (
+	"dns-server/internal/services"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Response rate limiting defaults, following BIND. Limiting is off unless
+// DNS_RRL_RESPONSES_PER_SECOND is set.
+const (
+	defaultRRLSlip       = 2
+	defaultRRLWindow     = 15 * time.Second
+	defaultRRLIPv4Prefix = 24
+	defaultRRLIPv6Prefix = 56
+)
+
+// rateLimiter implements response rate limiting (RRL) for UDP: identical
+// responses sent to one client netblock are limited to responsesPerSecond,
+// so the server is useless for amplifying traffic towards a spoofed
+// address. Every slip-th response over the limit is sent as an empty
+// truncated reply instead of being dropped, so a real client behind the
+// netblock retries over TCP, which is never limited.
+type rateLimiter struct {
+	responsesPerSecond float64
+	// slip is how many limited responses are dropped per truncated reply;
+	// 0 drops them all, 1 truncates them all
+	slip int
+	// window bounds how long a client that keeps asking stays limited
+	// after it slows down
+	window time.Duration
+	// ipv4Mask and ipv6Mask group client addresses into netblocks
+	ipv4Mask, ipv6Mask net.IPMask
+	// exempt lists the networks that are never limited
+	exempt []*net.IPNet
+
+	mu        sync.Mutex
+	buckets   map[string]*rrlBucket
+	lastSweep time.Time
+}
+
+// rrlBucket is the token bucket of one netblock and response.
+type rrlBucket struct {
+	// balance is the number of responses that may still be sent, negative
+	// while the bucket is limited
+	balance float64
+	last    time.Time
+	// limited counts responses over the limit, to pick the ones that slip
+	limited int
+}
+
+// newRateLimiter configures RRL from the environment. It returns nil when
+// limiting is disabled.
+func newRateLimiter() *rateLimiter {
+	rps, err := strconv.ParseFloat(os.Getenv("DNS_RRL_RESPONSES_PER_SECOND"), 64)
+	if err != nil || rps <= 0 {
+		return nil
+	}
+
+	rl := &rateLimiter{
+		responsesPerSecond: rps,
+		slip:               defaultRRLSlip,
+		window:             defaultRRLWindow,
+		ipv4Mask:           net.CIDRMask(defaultRRLIPv4Prefix, 32),
+		ipv6Mask:           net.CIDRMask(defaultRRLIPv6Prefix, 128),
+		buckets:            make(map[string]*rrlBucket),
+	}
+	if v, err := strconv.Atoi(os.Getenv("DNS_RRL_SLIP")); err == nil && v >= 0 {
+		rl.slip = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("DNS_RRL_WINDOW")); err == nil && v > 0 {
+		rl.window = time.Duration(v) * time.Second
+	}
+	if v, err := strconv.Atoi(os.Getenv("DNS_RRL_IPV4_PREFIX")); err == nil && v > 0 && v <= 32 {
+		rl.ipv4Mask = net.CIDRMask(v, 32)
+	}
+	if v, err := strconv.Atoi(os.Getenv("DNS_RRL_IPV6_PREFIX")); err == nil && v > 0 && v <= 128 {
+		rl.ipv6Mask = net.CIDRMask(v, 128)
+	}
+	rl.exempt = services.ParseNetworks(os.Getenv("DNS_RRL_EXEMPT"))
+
+	log.Printf("Response rate limiting at %g responses per second, slip %d", rps, rl.slip)
+	return rl
+}
+
+// wrap rate limits the UDP responses of next.
+func (rl *rateLimiter) wrap(next dns.Handler) dns.Handler {
+	return dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		if _, ok := w.RemoteAddr().(*net.UDPAddr); !ok {
+			next.ServeDNS(w, r)
+			return
+		}
+		ip := remoteIP(w.RemoteAddr())
+		if ip == nil || services.ContainsIP(rl.exempt, ip) {
+			next.ServeDNS(w, r)
+			return
+		}
+		next.ServeDNS(&rrlWriter{ResponseWriter: w, rl: rl, client: ip}, r)
+	})
+}
+
+// rrlWriter applies the rate limit to the response about to be written.
+type rrlWriter struct {
+	dns.ResponseWriter
+	rl     *rateLimiter
+	client net.IP
+}
+
+func (w *rrlWriter) WriteMsg(m *dns.Msg) error {
+	switch w.rl.limit(w.client, m) {
+	case rrlDrop:
+		rrlDropped.Add(1)
+		return nil
+	case rrlSlip:
+		rrlSlipped.Add(1)
+		tc := new(dns.Msg)
+		tc.MsgHdr = m.MsgHdr
+		tc.Question = m.Question
+		tc.Truncated = true
+		return w.ResponseWriter.WriteMsg(tc)
+	}
+	return w.ResponseWriter.WriteMsg(m)
+}
+
+// Outcomes of rateLimiter.limit.
+const (
+	rrlSend = iota
+	rrlDrop
+	rrlSlip
+)
+
+// limit takes a token from the bucket m belongs to and decides what to do
+// with it.
+func (rl *rateLimiter) limit(client net.IP, m *dns.Msg) int {
+	key := rl.key(client, m)
+	now := time.Now()
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.sweep(now)
+
+	b, ok := rl.buckets[key]
+	if !ok {
+		b = &rrlBucket{balance: rl.responsesPerSecond, last: now}
+		rl.buckets[key] = b
+	}
+	b.balance = min(b.balance+now.Sub(b.last).Seconds()*rl.responsesPerSecond, rl.responsesPerSecond)
+	b.last = now
+	b.balance--
+	// A client that keeps asking does not sink further than the window
+	b.balance = max(b.balance, -rl.window.Seconds()*rl.responsesPerSecond)
+	if b.balance >= 0 {
+		if b.limited > 0 {
+			log.Printf("RRL: stopped limiting %s", key)
+			b.limited = 0
+		}
+		return rrlSend
+	}
+
+	if b.limited == 0 {
+		log.Printf("RRL: limiting %s", key)
+	}
+	b.limited++
+	if rl.slip > 0 && b.limited%rl.slip == 0 {
+		return rrlSlip
+	}
+	return rrlDrop
+}
+
+// key identifies the bucket of response m to client: the client's netblock
+// together with what the response is about. As in BIND, negative answers
+// and referrals count against the zone or delegation instead of the
+// question, so random names do not each get a bucket of their own.
+func (rl *rateLimiter) key(client net.IP, m *dns.Msg) string {
+	netblock := client.Mask(rl.ipv6Mask)
+	if ip4 := client.To4(); ip4 != nil {
+		netblock = ip4.Mask(rl.ipv4Mask)
+	}
+
+	var qname, qtype string
+	if len(m.Question) > 0 {
+		qname = strings.ToLower(m.Question[0].Name)
+		qtype = dns.TypeToString[m.Question[0].Qtype]
+	}
+
+	switch {
+	case m.Rcode != dns.RcodeSuccess && m.Rcode != dns.RcodeNameError:
+		return netblock.String() + " error"
+	case len(m.Answer) > 0:
+		return netblock.String() + " " + qname + "/" + qtype
+	}
+	kind := "nodata"
+	if m.Rcode == dns.RcodeNameError {
+		kind = "nxdomain"
+	}
+	for _, rr := range m.Ns {
+		switch rr.Header().Rrtype {
+		case dns.TypeSOA:
+			return netblock.String() + " " + kind + " " + strings.ToLower(rr.Header().Name)
+		case dns.TypeNS:
+			return netblock.String() + " referral " + strings.ToLower(rr.Header().Name)
+		}
+	}
+	return netblock.String() + " " + kind + " " + qname
+}
+
+// sweep forgets the buckets that have been full again for a while. It runs
+// at most once per window.
+func (rl *rateLimiter) sweep(now time.Time) {
+	if now.Sub(rl.lastSweep) < rl.window {
+		return
+	}
+	rl.lastSweep = now
+	for key, b := range rl.buckets {
+		if now.Sub(b.last) > rl.window {
+			delete(rl.buckets, key)
+		}
+	}
+}
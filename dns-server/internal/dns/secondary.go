@@ -0,0 +1,347 @@
+package dns
+
+import (
+	"dns-server/internal/models"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// secondaryInterval is how often secondary zones are checked for a
+	// due refresh
+	secondaryInterval = 10 * time.Second
+	// secondaryInitialRetry is the retry interval of a secondary zone that
+	// has never been transferred and so has no SOA timers yet
+	secondaryInitialRetry = 5 * time.Minute
+)
+
+// primaryDialer connects to the primaries of secondary zones. They are set
+// by zone owners, so only public addresses are dialed.
+var primaryDialer = &net.Dialer{Timeout: 2 * time.Second, Control: dialPublicOnly}
+
+// runSecondaries keeps secondary zones in sync with their primaries,
+// refreshing each one when its SOA refresh (or, after a failure, retry)
+// interval has passed.
+func (s *DNSServer) runSecondaries() {
+	ticker := time.NewTicker(secondaryInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		domains, err := s.db.GetDueSecondaryZones(time.Now())
+		if err != nil {
+			log.Printf("Failed to fetch secondary zones to refresh: %v", err)
+			continue
+		}
+		for i := range domains {
+			go s.refreshZone(&domains[i])
+		}
+	}
+}
+
+// refreshZone checks the primary of a secondary zone for a newer serial and
+// transfers the zone if there is one, then schedules the next refresh from
+// the SOA timers (RFC 1035 section 4.3.5). A zone that cannot be refreshed
+// until its expiry stops being served.
+func (s *DNSServer) refreshZone(domain *models.Domain) {
+	// A NOTIFY may arrive while the timer-driven refresh is running
+	if _, busy := s.refreshing.LoadOrStore(domain.ID, true); busy {
+		return
+	}
+	defer s.refreshing.Delete(domain.ID)
+
+	now := time.Now()
+	soa, err := s.refreshSecondary(domain)
+	if err != nil {
+		retry := secondaryInitialRetry
+		if current := s.secondarySOA(domain); current != nil {
+			retry = time.Duration(current.Retry) * time.Second
+		}
+		log.Printf("Refresh of secondary zone %s from %s failed, retrying in %s: %v", domain.DomainName, *domain.Primary, retry, err)
+		if err := s.db.SetSecondaryZoneTimers(domain.ID.String(), now.Add(retry), nil); err != nil {
+			log.Printf("Failed to schedule refresh of %s: %v", domain.DomainName, err)
+		}
+		return
+	}
+
+	expires := now.Add(time.Duration(soa.Expire) * time.Second)
+	if err := s.db.SetSecondaryZoneTimers(domain.ID.String(), now.Add(time.Duration(soa.Refresh)*time.Second), &expires); err != nil {
+		log.Printf("Failed to schedule refresh of %s: %v", domain.DomainName, err)
+	}
+}
+
+// refreshSecondary brings a secondary zone up to date with its primary and
+// returns the primary's SOA.
+func (s *DNSServer) refreshSecondary(domain *models.Domain) (*dns.SOA, error) {
+	origin := dns.Fqdn(domain.DomainName)
+	addr := primaryAddr(*domain.Primary)
+
+	m := new(dns.Msg)
+	m.SetQuestion(origin, dns.TypeSOA)
+	c := &dns.Client{TsigProvider: tsigProvider{s}, Dialer: primaryDialer}
+	if err := s.signForPrimary(domain, m); err != nil {
+		return nil, err
+	}
+	r, _, err := c.Exchange(m, addr)
+	if err != nil {
+		return nil, err
+	}
+	if r.Rcode != dns.RcodeSuccess || !r.Authoritative || len(r.Answer) == 0 {
+		return nil, fmt.Errorf("SOA query answered with %s", dns.RcodeToString[r.Rcode])
+	}
+	soa, ok := r.Answer[0].(*dns.SOA)
+	if !ok {
+		return nil, errors.New("SOA query answered without SOA")
+	}
+
+	current := s.secondarySOA(domain)
+	if current != nil && !serialLess(current.Serial, soa.Serial) {
+		return soa, nil
+	}
+
+	records, serial, err := s.transferIn(domain, current)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.db.ReplaceSecondaryRecords(domain.ID.String(), int64(serial), records); err != nil {
+		return nil, err
+	}
+	log.Printf("Transferred secondary zone %s serial %d from %s: %d records", domain.DomainName, serial, addr, len(records))
+	return soa, nil
+}
+
+// transferIn pulls a secondary zone from its primary, incrementally when
+// current (the SOA of the data we have) is not nil, and returns the
+// complete new zone data and its serial.
+func (s *DNSServer) transferIn(domain *models.Domain, current *dns.SOA) ([]models.Record, uint32, error) {
+	origin := dns.Fqdn(domain.DomainName)
+
+	m := new(dns.Msg)
+	have := make(map[string]dns.RR)
+	if current != nil {
+		m.SetIxfr(origin, current.Serial, current.Ns, current.Mbox)
+		z, err := s.loadZone(domain)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, records := range z.records {
+			for _, record := range records {
+				rr, err := z.recordToRR(z.fqdn(strings.ToLower(record.Name)), record)
+				if err != nil {
+					continue
+				}
+				have[rrKey(rr)] = rr
+			}
+		}
+	} else {
+		m.SetAxfr(origin)
+	}
+
+	if err := s.signForPrimary(domain, m); err != nil {
+		return nil, 0, err
+	}
+	conn, err := primaryDialer.Dial("tcp", primaryAddr(*domain.Primary))
+	if err != nil {
+		return nil, 0, err
+	}
+	// The transfer closes the connection when it ends. It verifies every
+	// message once it has a TSIG provider, so it only gets one when the
+	// request is signed.
+	tr := &dns.Transfer{Conn: &dns.Conn{Conn: conn}}
+	if domain.PrimaryTSIGKey != nil {
+		tr.TsigProvider = tsigProvider{s}
+	}
+	ch, err := tr.In(m, primaryAddr(*domain.Primary))
+	if err != nil {
+		conn.Close()
+		return nil, 0, err
+	}
+	var rrs []dns.RR
+	for envelope := range ch {
+		if envelope.Error != nil {
+			return nil, 0, envelope.Error
+		}
+		rrs = append(rrs, envelope.RR...)
+	}
+
+	zone, serial, err := applyTransfer(have, rrs)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	records := make([]models.Record, 0, len(zone))
+	for _, rr := range zone {
+		h := rr.Header()
+		name := normalizeName(h.Name)
+		if !dns.IsSubDomain(origin, dns.Fqdn(name)) {
+			continue
+		}
+		records = append(records, models.Record{
+			DomainID: domain.ID,
+			Name:     relativeName(name, domain.DomainName),
+			Type:     dns.TypeToString[h.Rrtype],
+			TTL:      int(h.Ttl),
+			Value:    strings.TrimPrefix(rr.String(), h.String()),
+		})
+	}
+	return records, serial, nil
+}
+
+// applyTransfer builds the zone data resulting from a transfer response.
+// rrs is either a full zone (SOA, records, SOA) or the difference sequences
+// of an incremental transfer to apply to have (RFC 1995 section 4).
+func applyTransfer(have map[string]dns.RR, rrs []dns.RR) (map[string]dns.RR, uint32, error) {
+	if len(rrs) == 0 {
+		return nil, 0, errors.New("empty transfer")
+	}
+	soa, ok := rrs[0].(*dns.SOA)
+	if !ok {
+		return nil, 0, errors.New("transfer does not start with SOA")
+	}
+
+	// A lone SOA means there is nothing newer than what we have
+	zone := make(map[string]dns.RR)
+	incremental := len(rrs) == 1 || (len(rrs) > 2 && rrs[1].Header().Rrtype == dns.TypeSOA)
+	if incremental {
+		for key, rr := range have {
+			zone[key] = rr
+		}
+	}
+
+	// The records between the opening and closing SOA. In an incremental
+	// transfer every SOA among them switches between the records deleted
+	// from and the records added to the previous version.
+	body := rrs[1:]
+	if len(body) > 0 {
+		body = body[:len(body)-1]
+	}
+	deleting := false
+	for _, rr := range body {
+		if incremental && rr.Header().Rrtype == dns.TypeSOA {
+			deleting = !deleting
+			continue
+		}
+		if deleting {
+			delete(zone, rrKey(rr))
+		} else {
+			zone[rrKey(rr)] = rr
+		}
+	}
+
+	// The new SOA replaces whichever one we had
+	for key, rr := range zone {
+		if rr.Header().Rrtype == dns.TypeSOA {
+			delete(zone, key)
+		}
+	}
+	zone[rrKey(soa)] = soa
+	return zone, soa.Serial, nil
+}
+
+// secondarySOA returns the SOA of the data we hold for a secondary zone, or
+// nil if it has not been transferred yet.
+func (s *DNSServer) secondarySOA(domain *models.Domain) *dns.SOA {
+	z, err := s.loadZone(domain)
+	if err != nil {
+		return nil
+	}
+	soa, _ := z.soa().(*dns.SOA)
+	return soa
+}
+
+// signForPrimary signs m with the TSIG key configured for the primary of
+// domain, if any.
+func (s *DNSServer) signForPrimary(domain *models.Domain, m *dns.Msg) error {
+	if domain.PrimaryTSIGKey == nil {
+		return nil
+	}
+	key, err := s.tsigKey(*domain.PrimaryTSIGKey)
+	if err != nil {
+		return fmt.Errorf("TSIG key %s: %v", *domain.PrimaryTSIGKey, err)
+	}
+	m.SetTsig(dns.Fqdn(key.Name), tsigAlgorithm(key), 300, time.Now().Unix())
+	return nil
+}
+
+// handleNotify accepts a NOTIFY (RFC 1996) from the primary of one of our
+// secondary zones and refreshes the zone right away.
+func (s *DNSServer) handleNotify(w dns.ResponseWriter, r *dns.Msg) {
+	q := r.Question[0]
+	client := remoteIP(w.RemoteAddr())
+
+	domain, owner, err := s.findZone(q.Name)
+	if err != nil {
+		log.Printf("DB query error for %s: %v", q.Name, err)
+		s.refuseTransfer(w, r, dns.RcodeServerFailure, "")
+		return
+	}
+	if domain == nil || owner != "@" || domain.Primary == nil {
+		s.refuseTransfer(w, r, dns.RcodeNotAuth, "NOTIFY for %s from %s: not a secondary zone", q.Name, client)
+		return
+	}
+
+	tsig := r.IsTsig()
+	if tsig != nil && w.TsigStatus() != nil {
+		s.refuseTransfer(w, r, dns.RcodeNotAuth, "NOTIFY for %s from %s: TSIG %s: %v", q.Name, client, tsig.Hdr.Name, w.TsigStatus())
+		return
+	}
+	if domain.PrimaryTSIGKey != nil && (tsig == nil || normalizeName(tsig.Hdr.Name) != normalizeName(*domain.PrimaryTSIGKey)) {
+		s.refuseTransfer(w, r, dns.RcodeRefused, "NOTIFY for %s from %s refused: TSIG key %s required", q.Name, client, *domain.PrimaryTSIGKey)
+		return
+	}
+	if !isPrimary(*domain.Primary, client) {
+		s.refuseTransfer(w, r, dns.RcodeRefused, "NOTIFY for %s from %s refused: not the primary", q.Name, client)
+		return
+	}
+
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.Authoritative = true
+	signReply(w, r, m)
+	if err := w.WriteMsg(m); err != nil {
+		log.Printf("Failed to write DNS response: %v", err)
+	}
+
+	log.Printf("NOTIFY for %s from %s, refreshing", domain.DomainName, client)
+	go s.refreshZone(domain)
+}
+
+// primaryAddr turns a configured primary, "host" or "host:port", into an
+// address to dial.
+func primaryAddr(primary string) string {
+	if _, _, err := net.SplitHostPort(primary); err == nil {
+		return primary
+	}
+	return net.JoinHostPort(strings.Trim(primary, "[]"), "53")
+}
+
+// isPrimary reports whether ip is an address of the configured primary.
+func isPrimary(primary string, ip net.IP) bool {
+	host, _, _ := net.SplitHostPort(primaryAddr(primary))
+	if addr := net.ParseIP(host); addr != nil {
+		return addr.Equal(ip)
+	}
+	addrs, err := net.LookupIP(host)
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if addr.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// rrKey identifies a record by its owner, type and data, ignoring the TTL.
+func rrKey(rr dns.RR) string {
+	rr = dns.Copy(rr)
+	rr.Header().Ttl = 0
+	rr.Header().Name = strings.ToLower(rr.Header().Name)
+	return rr.String()
+}
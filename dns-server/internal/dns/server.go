@@ -2,163 +2,153 @@ package dns
 
 import (
 	"dns-server/internal/database"
-	"fmt"
+	"dns-server/internal/services"
 	"log"
+	"net"
 	"os"
-	"strings"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/miekg/dns"
 )
 
 type DNSServer struct {
 	db database.Service
+
+	// ednsBufferSize is the largest UDP response we send to EDNS clients
+	ednsBufferSize uint16
+
+	// refreshing holds the IDs of the secondary zones being refreshed
+	refreshing sync.Map
+
+	// zones caches the hosted zones for answering questions
+	zones *zoneCache
+	// serveStale is how long cached zones keep being served after they
+	// stopped being current, when the database cannot be reached
+	serveStale time.Duration
+	// stale is set while stale zone data is being served
+	stale atomic.Bool
+	// snapshotPath is the file the zone cache is saved to, if any
+	snapshotPath string
+
+	// rrl rate limits UDP responses, nil when disabled
+	rrl *rateLimiter
+
+	// views are the split-horizon views clients are matched against, in
+	// order, and ecsTrusted the resolvers whose EDNS Client Subnet option
+	// is used to do so
+	views      []services.View
+	ecsTrusted []*net.IPNet
+
+	// maxAnswers caps how many values of an RRset an answer holds, 0 for
+	// no cap, and rotation turns the order of unweighted RRsets
+	maxAnswers int
+	rotation   atomic.Uint64
+	// health runs the health checks of records and knows which targets
+	// are down
+	health *healthChecker
+
+	// outOfZonePolicy maps listener transports to what is done with
+	// questions outside our zones
+	outOfZonePolicy map[string]string
+	// forwarder resolves such questions upstream, nil when no upstream
+	// resolvers are configured
+	forwarder *forwarder
+
+	// tsigSecrets maps fully qualified names of server-wide TSIG keys,
+	// which are not tied to a domain, to their base64 secrets
+	tsigSecrets map[string]string
 }
 
+// defaultServeStale is how long zone data is served while the database is
+// unavailable, unless DNS_SERVE_STALE says otherwise (RFC 8767 suggests one
+// to three days).
+const defaultServeStale = 24 * time.Hour
+
 func NewDNSServer(db database.Service) *DNSServer {
-	return &DNSServer{db: db}
+	bufferSize := uint16(defaultEDNSBufferSize)
+	if v, err := strconv.ParseUint(os.Getenv("DNS_EDNS_BUFFER_SIZE"), 10, 16); err == nil && v >= dns.MinMsgSize {
+		bufferSize = uint16(v)
+	}
+
+	serveStale := defaultServeStale
+	if v, err := time.ParseDuration(os.Getenv("DNS_SERVE_STALE")); err == nil && v >= 0 {
+		serveStale = v
+	}
+
+	return &DNSServer{
+		db:              db,
+		ednsBufferSize:  bufferSize,
+		zones:           newZoneCache(),
+		serveStale:      serveStale,
+		snapshotPath:    os.Getenv("DNS_ZONE_SNAPSHOT"),
+		rrl:             newRateLimiter(),
+		views:           services.DNSViews(),
+		ecsTrusted:      services.ParseNetworks(os.Getenv("DNS_ECS_TRUSTED")),
+		maxAnswers:      maxAnswersFromEnv(),
+		health:          newHealthChecker(),
+		outOfZonePolicy: outOfZonePolicies(),
+		forwarder:       newForwarder(),
+		tsigSecrets:     services.ServerTSIGKeys(),
+	}
 }
 
 func (s *DNSServer) handleDNSRequest(w dns.ResponseWriter, r *dns.Msg) {
-    m := new(dns.Msg)
-    m.SetReply(r)
-    m.Authoritative = true
-    m.Rcode = dns.RcodeSuccess // Default response code
-
-    fmt.Println("HANDLE DNS REQUEST")
-
-    for _, q := range r.Question {
-        name := q.Name
-        if name[len(name)-1] == '.' {
-            name = name[:len(name)-1]
-        }
-
-        sub, domain := splitDomainHeuristic(name)
-
-        // Check if the domain exists in the database
-        soaRecords, err := s.db.GetRecordsByName(domain, "@")
-        if err != nil {
-            log.Printf("DB query error for SOA %s: %v", domain, err)
-            m.Rcode = dns.RcodeServerFailure
-            continue
-        }
-
-        // If no SOA record exists, this domain is not under our authority
-        var soaRR dns.RR
-        for _, rec := range soaRecords {
-            if rec.Type == "SOA" {
-                // Ensure proper SOA format: <name> <ttl> IN SOA <mname> <rname> <serial> <refresh> <retry> <expire> <minimum>
-                soaStr := fmt.Sprintf("%s %d IN SOA %s", domain+".", rec.TTL, rec.Value)
-                soaRR, err = dns.NewRR(soaStr)
-                if err != nil || soaRR == nil {
-                    log.Printf("Invalid SOA record for %s: %v", domain, err)
-                    m.Rcode = dns.RcodeServerFailure
-                    continue
-                }
-                break
-            }
-        }
-
-        // Query for the requested records
-        records, err := s.db.GetRecordsByName(domain, sub)
-        if err != nil {
-            log.Printf("DB query error for %s.%s: %v", sub, domain, err)
-            m.Rcode = dns.RcodeServerFailure
-            continue
-        }
-
-        if len(records) == 0 {
-            // No records found, return NXDOMAIN with SOA in AUTHORITY
-            m.Rcode = dns.RcodeNameError
-            if soaRR != nil {
-                m.Ns = append(m.Ns, soaRR)
-            }
-        } else {
-            // Records found, add them to the ANSWER section
-            for _, record := range records {
-                var rr dns.RR
-                switch record.Type {
-                case "A":
-                    rr, _ = dns.NewRR(fmt.Sprintf("%s %d IN A %s", q.Name, record.TTL, record.Value))
-                case "AAAA":
-                    rr, _ = dns.NewRR(fmt.Sprintf("%s %d IN AAAA %s", q.Name, record.TTL, record.Value))
-                case "CNAME":
-                    rr, _ = dns.NewRR(fmt.Sprintf("%s %d IN CNAME %s", q.Name, record.TTL, record.Value))
-                case "MX":
-                    p := 10
-                    if record.Priority != nil {
-                        p = *record.Priority
-                    }
-                    rr, _ = dns.NewRR(fmt.Sprintf("%s %d IN MX %d %s", q.Name, record.TTL, p, record.Value))
-                case "TXT":
-                    rr, _ = dns.NewRR(fmt.Sprintf("%s %d IN TXT \"%s\"", q.Name, record.TTL, record.Value))
-                case "NS":
-                    rr, _ = dns.NewRR(fmt.Sprintf("%s %d IN NS %s", q.Name, record.TTL, record.Value))
-                case "SRV":
-                    p := 0
-                    if record.Priority != nil {
-                        p = *record.Priority
-                    }
-                    rr, _ = dns.NewRR(fmt.Sprintf("%s %d IN SRV %d 0 0 %s", q.Name, record.TTL, p, record.Value))
-                case "CAA":
-                    rr, _ = dns.NewRR(fmt.Sprintf("%s %d IN CAA 0 issue \"%s\"", q.Name, record.TTL, record.Value))
-                default:
-                    log.Printf("Unsupported record type: %s for %s", record.Type, q.Name)
-                    continue
-                }
-
-                if rr != nil {
-                    m.Answer = append(m.Answer, rr)
-                }
-            }
-        }
-
-        // Always include NS records in the AUTHORITY section if available
-        nsRecords, err := s.db.GetRecordsByName(domain, "@")
-        if err == nil {
-            for _, rec := range nsRecords {
-                if rec.Type == "NS" {
-                    nsRR, err := dns.NewRR(fmt.Sprintf("%s %d IN NS %s", domain+".", rec.TTL, rec.Value))
-                    if err == nil && nsRR != nil {
-                        m.Ns = append(m.Ns, nsRR)
-                    }
-                }
-            }
-        }
-
-        // Include SOA in AUTHORITY if no other records are present and not NXDOMAIN
-        if len(m.Answer) == 0 && m.Rcode != dns.RcodeNameError && soaRR != nil {
-            m.Ns = append(m.Ns, soaRR)
-        }
-    }
-
-    if err := w.WriteMsg(m); err != nil {
-        log.Printf("Failed to write DNS response: %v", err)
-    }
-}
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.Authoritative = true
+	m.Rcode = dns.RcodeSuccess // Default response code
+
+	switch r.Opcode {
+	case dns.OpcodeNotify:
+		// The primary of a secondary zone announces changes
+		s.handleNotify(w, r)
+		return
+	case dns.OpcodeUpdate:
+		s.handleUpdate(w, r)
+		return
+	}
+
+	// Zone transfers stream the whole zone over several messages
+	if len(r.Question) == 1 && (r.Question[0].Qtype == dns.TypeAXFR || r.Question[0].Qtype == dns.TypeIXFR) {
+		s.handleTransfer(w, r)
+		return
+	}
 
+	// A signed request whose signature does not verify is not answered
+	// (RFC 8945 section 5.2)
+	if tsig := r.IsTsig(); tsig != nil && w.TsigStatus() != nil {
+		log.Printf("TSIG %s from %s: %v", tsig.Hdr.Name, w.RemoteAddr(), w.TsigStatus())
+		m.Authoritative = false
+		m.Rcode = dns.RcodeNotAuth
+		if err := w.WriteMsg(m); err != nil {
+			log.Printf("Failed to write DNS response: %v", err)
+		}
+		return
+	}
 
-func splitDomainHeuristic(fullName string) (subdomain, domain string) {
-    if fullName[len(fullName)-1] == '.' {
-        fullName = fullName[:len(fullName)-1]
-    }
-    parts := strings.Split(fullName, ".")
-    if len(parts) < 2 {
-        return "@", fullName
-    }
-    // Use the last two parts for the domain (e.g., bhaskar.codes)
-    domain = strings.Join(parts[max(0, len(parts)-2):], ".")
-    subdomain = strings.Join(parts[:max(0, len(parts)-2)], ".")
-    if subdomain == "" {
-        subdomain = "@"
-    }
-    return subdomain, domain
-}
+	if s.setupEDNS(r, m) {
+		switch {
+		case len(r.Question) != 1:
+			m.Authoritative = false
+			m.Rcode = dns.RcodeFormatError
+		case !s.hosted(r.Question[0].Name):
+			m = s.outOfZone(w, r, m)
+		default:
+			s.answer(r.Question[0], m, dnssecOK(r), s.clientOf(w, r, m))
+		}
+	}
+
+	// Oversized UDP responses are truncated with TC set so the client
+	// retries over TCP. Room is left for the TSIG record of a signed reply.
+	m.Truncate(s.maxResponseSize(w, r) - tsigSize(r))
+	signReply(w, r, m)
 
-func max(a, b int) int {
-    if a > b {
-        return a
-    }
-    return b
+	if err := w.WriteMsg(m); err != nil {
+		log.Printf("Failed to write DNS response: %v", err)
+	}
 }
 
 func (s *DNSServer) StartDnsServer() {
@@ -167,21 +157,40 @@ func (s *DNSServer) StartDnsServer() {
 		port = "53" // default DNS port
 	}
 
-	dns.HandleFunc(".", s.handleDNSRequest)
+	var handler dns.Handler = dns.HandlerFunc(s.handleDNSRequest)
+	if s.rrl != nil {
+		handler = s.rrl.wrap(handler)
+	}
+	dns.Handle(".", handler)
+
+	if s.snapshotPath != "" {
+		if err := s.readSnapshot(); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to read zone snapshot %s: %v", s.snapshotPath, err)
+		}
+		go s.runSnapshots()
+	}
+	go s.runZoneCache()
+	go s.runRollovers()
+	go s.runNotifier()
+	go s.runSecondaries()
+	go s.runHealthChecks()
 
 	// Start UDP server
 	go func() {
-        server := &dns.Server{Addr: ":" + port, Net: "udp"}
-        log.Printf("Starting DNS server on udp://0.0.0.0:%s\n", port)
-        if err := server.ListenAndServe(); err != nil {
-            log.Fatalf("Failed to start UDP server: %s\n", err.Error())
-        }
-    }()
-
-    // Start TCP server
-    server := &dns.Server{Addr: ":" + port, Net: "tcp"}
-    log.Printf("Starting DNS server on tcp://0.0.0.0:%s\n", port)
-    if err := server.ListenAndServe(); err != nil {
-        log.Fatalf("Failed to start TCP server: %s\n", err.Error())
-    }
+		server := &dns.Server{Addr: ":" + port, Net: "udp", TsigProvider: tsigProvider{s}, MsgAcceptFunc: acceptMsg}
+		log.Printf("Starting DNS server on udp://0.0.0.0:%s\n", port)
+		if err := server.ListenAndServe(); err != nil {
+			log.Fatalf("Failed to start UDP server: %s\n", err.Error())
+		}
+	}()
+
+	// Start DNS-over-TLS server, if configured
+	s.startTLS()
+
+	// Start TCP server
+	server := &dns.Server{Addr: ":" + port, Net: "tcp", TsigProvider: tsigProvider{s}, MsgAcceptFunc: acceptMsg}
+	log.Printf("Starting DNS server on tcp://0.0.0.0:%s\n", port)
+	if err := server.ListenAndServe(); err != nil {
+		log.Fatalf("Failed to start TCP server: %s\n", err.Error())
+	}
 }
@@ -0,0 +1,95 @@
+package dns
+
+import (
+	"dns-server/internal/models"
+	"encoding/gob"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// snapshotInterval is how often a changed zone cache is written to disk.
+const snapshotInterval = time.Minute
+
+// snapshot is the on-disk form of the zone cache, read at startup so stale
+// data can be served when the database is down from the beginning.
+type snapshot struct {
+	// Written is when the zones were last known to be current
+	Written time.Time
+	Zones   []zoneSnapshot
+}
+
+// zoneSnapshot holds what a zone is built from.
+type zoneSnapshot struct {
+	Domain  models.Domain
+	Records []models.Record
+	Keys    []models.DNSSECKey
+}
+
+// runSnapshots writes the zone cache to snapshotPath whenever it is current
+// and changed since it was last written.
+func (s *DNSServer) runSnapshots() {
+	ticker := time.NewTicker(snapshotInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := s.writeSnapshot(); err != nil {
+			log.Printf("Failed to write zone snapshot %s: %v", s.snapshotPath, err)
+		}
+	}
+}
+
+// writeSnapshot writes the zone cache to snapshotPath if it is current and
+// changed. The file holds private DNSSEC keys and is only readable by us.
+func (s *DNSServer) writeSnapshot() error {
+	c := s.zones
+	c.mu.Lock()
+	if !c.current || !c.changed {
+		c.mu.Unlock()
+		return nil
+	}
+	snap := snapshot{Written: time.Now(), Zones: make([]zoneSnapshot, 0, len(c.zones))}
+	for _, z := range c.zones {
+		snap.Zones = append(snap.Zones, zoneSnapshot{Domain: *z.Domain, Records: z.stored, Keys: z.dnssecKeys})
+	}
+	c.changed = false
+	c.mu.Unlock()
+
+	// Write to a temporary file first so a crash never leaves half a snapshot
+	tmp, err := os.CreateTemp(filepath.Dir(s.snapshotPath), filepath.Base(s.snapshotPath)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := gob.NewEncoder(tmp).Encode(&snap); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.snapshotPath)
+}
+
+// readSnapshot fills the zone cache from snapshotPath. The zones are stale
+// since the snapshot was written and only used if the database is down.
+func (s *DNSServer) readSnapshot() error {
+	f, err := os.Open(s.snapshotPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var snap snapshot
+	if err := gob.NewDecoder(f).Decode(&snap); err != nil {
+		return err
+	}
+	zones := make([]*zone, 0, len(snap.Zones))
+	for i := range snap.Zones {
+		zones = append(zones, newZone(&snap.Zones[i].Domain, snap.Zones[i].Records, snap.Zones[i].Keys))
+	}
+	s.zones.replace(zones, false, snap.Written)
+	log.Printf("Read %d zones from snapshot %s written at %s", len(zones), s.snapshotPath, snap.Written.Format(time.RFC3339))
+	return nil
+}
@@ -0,0 +1,89 @@
+package dns
+
+import (
+	"crypto/tls"
+	"log"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/miekg/dns"
+)
+
+// defaultTLSPort is the DNS-over-TLS port (RFC 7858).
+const defaultTLSPort = "853"
+
+// certReloader holds the certificate served to DNS-over-TLS clients and
+// reads it again from disk on SIGHUP, so renewed certificates are picked
+// up without a restart.
+type certReloader struct {
+	certFile, keyFile string
+	cert              atomic.Pointer[tls.Certificate]
+}
+
+// newCertReloader loads the certificate and starts watching for SIGHUP.
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := c.reload(); err != nil {
+				// Keep serving the certificate we have
+				log.Printf("Failed to reload TLS certificate %s: %v", c.certFile, err)
+				continue
+			}
+			log.Printf("Reloaded TLS certificate %s", c.certFile)
+		}
+	}()
+	return c, nil
+}
+
+func (c *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	c.cert.Store(&cert)
+	return nil
+}
+
+func (c *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.cert.Load(), nil
+}
+
+// startTLS serves DNS-over-TLS when DNS_TLS_CERT and DNS_TLS_KEY name a
+// certificate and its key. Queries go to the same handler as UDP and TCP.
+func (s *DNSServer) startTLS() {
+	certFile, keyFile := os.Getenv("DNS_TLS_CERT"), os.Getenv("DNS_TLS_KEY")
+	if certFile == "" || keyFile == "" {
+		return
+	}
+	port := os.Getenv("DNS_TLS_PORT")
+	if port == "" {
+		port = defaultTLSPort
+	}
+
+	certs, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		log.Fatalf("Failed to load TLS certificate: %s\n", err.Error())
+	}
+	server := &dns.Server{
+		Addr:          ":" + port,
+		Net:           "tcp-tls",
+		TLSConfig:     &tls.Config{GetCertificate: certs.getCertificate, MinVersion: tls.VersionTLS12},
+		TsigProvider:  tsigProvider{s},
+		MsgAcceptFunc: acceptMsg,
+	}
+	go func() {
+		log.Printf("Starting DNS server on tls://0.0.0.0:%s\n", port)
+		if err := server.ListenAndServe(); err != nil {
+			log.Fatalf("Failed to start TLS server: %s\n", err.Error())
+		}
+	}()
+}
@@ -0,0 +1,260 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/miekg/dns"
+)
+
+// transferEnvelopeSize is roughly how many bytes of records go into one
+// message of a zone transfer, well below the 64KB a TCP message can hold.
+const transferEnvelopeSize = 16 * 1024
+
+// handleTransfer answers an AXFR or IXFR request for one of our zones.
+// Transfers are only served to the zone's secondaries, and to those
+// configured with a TSIG key only when the request is signed with it. AXFR
+// needs TCP; IXFR over UDP is answered with the current SOA alone, telling
+// the client to retry over TCP if it is behind (RFC 1995 section 2).
+func (s *DNSServer) handleTransfer(w dns.ResponseWriter, r *dns.Msg) {
+	q := r.Question[0]
+	qtype := dns.TypeToString[q.Qtype]
+	client := remoteIP(w.RemoteAddr())
+	udp := w.RemoteAddr().Network() != "tcp"
+
+	if udp && q.Qtype == dns.TypeAXFR {
+		s.refuseTransfer(w, r, dns.RcodeRefused, "AXFR of %s from %s over UDP", q.Name, client)
+		return
+	}
+
+	// An IXFR request carries the client's current SOA in AUTHORITY
+	var clientSOA *dns.SOA
+	if q.Qtype == dns.TypeIXFR {
+		if len(r.Ns) > 0 {
+			clientSOA, _ = r.Ns[0].(*dns.SOA)
+		}
+		if clientSOA == nil {
+			s.refuseTransfer(w, r, dns.RcodeFormatError, "IXFR of %s from %s without SOA", q.Name, client)
+			return
+		}
+	}
+
+	domain, owner, err := s.findZone(q.Name)
+	if err != nil {
+		log.Printf("DB query error for %s: %v", q.Name, err)
+		s.refuseTransfer(w, r, dns.RcodeServerFailure, "")
+		return
+	}
+	if domain == nil || owner != "@" {
+		s.refuseTransfer(w, r, dns.RcodeNotAuth, "%s of %s from %s: not a hosted zone", qtype, q.Name, client)
+		return
+	}
+
+	if tsig := r.IsTsig(); tsig != nil && w.TsigStatus() != nil {
+		s.refuseTransfer(w, r, dns.RcodeNotAuth, "%s of %s from %s: TSIG %s: %v", qtype, q.Name, client, tsig.Hdr.Name, w.TsigStatus())
+		return
+	}
+	if err := s.authorizeTransfer(r, domain.ID, client); err != nil {
+		s.refuseTransfer(w, r, dns.RcodeRefused, "%s of %s from %s refused: %v", qtype, q.Name, client, err)
+		return
+	}
+
+	z, err := s.loadZone(domain)
+	if err != nil {
+		log.Printf("Failed to load zone %s: %v", domain.DomainName, err)
+		s.refuseTransfer(w, r, dns.RcodeServerFailure, "")
+		return
+	}
+	if z.expired() {
+		s.refuseTransfer(w, r, dns.RcodeServerFailure, "%s of %s from %s: secondary zone has expired", qtype, q.Name, client)
+		return
+	}
+
+	var rrs []dns.RR
+	switch {
+	case q.Qtype == dns.TypeAXFR:
+		rrs, err = z.axfr()
+	case udp:
+		rrs = []dns.RR{z.soa()}
+	default:
+		rrs, err = s.ixfr(z, clientSOA.Serial)
+	}
+	if err == nil && rrs[0] == nil {
+		err = errors.New("zone has no SOA record")
+	}
+	if err != nil {
+		log.Printf("Failed to build %s of %s: %v", qtype, domain.DomainName, err)
+		s.refuseTransfer(w, r, dns.RcodeServerFailure, "")
+		return
+	}
+
+	if udp {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Authoritative = true
+		m.Answer = rrs
+		signReply(w, r, m)
+		if err := w.WriteMsg(m); err != nil {
+			log.Printf("Failed to write DNS response: %v", err)
+		}
+		return
+	}
+
+	if err := sendTransfer(w, r, rrs); err != nil {
+		log.Printf("%s of %s to %s failed: %v", qtype, domain.DomainName, client, err)
+		return
+	}
+	log.Printf("%s of %s to %s: %d records", qtype, domain.DomainName, client, len(rrs))
+}
+
+// authorizeTransfer checks that the request is signed with one of the
+// zone's TSIG keys that may transfer it, or that client is one of the
+// zone's secondaries and the request is signed with the key that secondary
+// needs, if any. The signature itself has already been verified by the
+// server.
+func (s *DNSServer) authorizeTransfer(r *dns.Msg, domainID uuid.UUID, client net.IP) error {
+	tsig := r.IsTsig()
+
+	// A transfer key of the domain is allowed from anywhere
+	if tsig != nil {
+		key, err := s.tsigKey(tsig.Hdr.Name)
+		if err != nil {
+			return err
+		}
+		if key.DomainID == domainID && key.CanTransfer {
+			return nil
+		}
+	}
+
+	secondaries, err := s.db.GetSecondariesByDomain(domainID.String())
+	if err != nil {
+		return err
+	}
+	for _, secondary := range secondaries {
+		if !client.Equal(net.ParseIP(secondary.Address)) {
+			continue
+		}
+		if secondary.TSIGKeyName == nil {
+			return nil
+		}
+		if tsig == nil || normalizeName(tsig.Hdr.Name) != normalizeName(*secondary.TSIGKeyName) {
+			return fmt.Errorf("TSIG key %s required", *secondary.TSIGKeyName)
+		}
+		return nil
+	}
+	return errors.New("not a secondary of the zone")
+}
+
+// refuseTransfer answers a transfer request with rcode, logging why when
+// format is not empty.
+func (s *DNSServer) refuseTransfer(w dns.ResponseWriter, r *dns.Msg, rcode int, format string, args ...interface{}) {
+	if format != "" {
+		log.Printf(format, args...)
+	}
+	m := new(dns.Msg)
+	m.SetRcode(r, rcode)
+	signReply(w, r, m)
+	if err := w.WriteMsg(m); err != nil {
+		log.Printf("Failed to write DNS response: %v", err)
+	}
+}
+
+// axfr returns the zone as it is sent in a full transfer: the SOA, every
+// other record (with signatures and the NSEC chain if the zone is signed),
+// and the SOA again.
+func (z *zone) axfr() ([]dns.RR, error) {
+	soa := z.soa()
+	if soa == nil {
+		return nil, errors.New("zone has no SOA record")
+	}
+
+	owners := make([]string, 0, len(z.records)+1)
+	for owner := range z.records {
+		if owner != "@" {
+			owners = append(owners, owner)
+		}
+	}
+	sort.Slice(owners, func(i, j int) bool { return canonicalLess(z.fqdn(owners[i]), z.fqdn(owners[j])) })
+	owners = append([]string{"@"}, owners...)
+
+	rrs := z.sign([]dns.RR{soa}, true, "")
+	for _, owner := range owners {
+		var records []dns.RR
+		for _, rr := range z.rrset(z.fqdn(owner), owner, dns.TypeANY) {
+			if rr.Header().Rrtype != dns.TypeSOA {
+				records = append(records, rr)
+			}
+		}
+
+		// The NS set at a cut and the glue below it belong to the child
+		// and are not signed
+		if _, ok := z.delegation(owner); ok {
+			rrs = append(rrs, records...)
+		} else {
+			rrs = append(rrs, z.sign(records, true, "")...)
+		}
+	}
+
+	if z.keys != nil {
+		for _, name := range z.nsecChain() {
+			rrs = append(rrs, z.sign([]dns.RR{z.nsec(name)}, true, "")...)
+		}
+	}
+	return append(rrs, dns.Copy(soa)), nil
+}
+
+// sendTransfer writes rrs as a sequence of messages answering r.
+func sendTransfer(w dns.ResponseWriter, r *dns.Msg, rrs []dns.RR) error {
+	ch := make(chan *dns.Envelope)
+	errc := make(chan error, 1)
+	go func() {
+		tr := new(dns.Transfer)
+		errc <- tr.Out(w, r, ch)
+	}()
+
+	defer w.Close()
+
+	// send hands an envelope to the writer, giving up once it has failed
+	send := func(envelope []dns.RR) error {
+		select {
+		case ch <- &dns.Envelope{RR: envelope}:
+			return nil
+		case err := <-errc:
+			return err
+		}
+	}
+
+	var envelope []dns.RR
+	size := 0
+	for _, rr := range rrs {
+		envelope = append(envelope, rr)
+		size += dns.Len(rr)
+		if size >= transferEnvelopeSize {
+			if err := send(envelope); err != nil {
+				return err
+			}
+			envelope, size = nil, 0
+		}
+	}
+	if len(envelope) > 0 {
+		if err := send(envelope); err != nil {
+			return err
+		}
+	}
+	close(ch)
+	return <-errc
+}
+
+// remoteIP returns the IP address of a client.
+func remoteIP(addr net.Addr) net.IP {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+	return net.ParseIP(strings.Trim(host, "[]"))
+}
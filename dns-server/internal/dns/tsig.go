@@ -0,0 +1,121 @@
+package dns
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"database/sql"
+	"dns-server/internal/models"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// tsigKey returns the TSIG key called name: a server-wide key from
+// DNS_TSIG_KEYS, which has no domain and may be used with HMAC-SHA256 or
+// HMAC-SHA512, or else one stored for a domain. Server-wide keys come first
+// so a domain's key cannot shadow them.
+func (s *DNSServer) tsigKey(name string) (*models.TSIGKey, error) {
+	if secret, ok := s.tsigSecrets[dns.CanonicalName(name)]; ok {
+		return &models.TSIGKey{Name: normalizeName(name), Secret: secret}, nil
+	}
+
+	key, err := s.db.GetTSIGKeyByName(normalizeName(name))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, dns.ErrSecret
+	}
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// tsigAlgorithm returns the algorithm to sign with key.
+func tsigAlgorithm(key *models.TSIGKey) string {
+	if key.Algorithm == "" {
+		return dns.HmacSHA256
+	}
+	return dns.CanonicalName(key.Algorithm)
+}
+
+// tsigProvider computes and checks TSIG MACs (RFC 8945) with the keys
+// tsigKey finds, for the DNS listeners and our own outgoing messages.
+type tsigProvider struct {
+	s *DNSServer
+}
+
+func (p tsigProvider) Generate(msg []byte, t *dns.TSIG) ([]byte, error) {
+	key, err := p.s.tsigKey(t.Hdr.Name)
+	if err != nil {
+		return nil, err
+	}
+	if key.Algorithm != "" && dns.CanonicalName(key.Algorithm) != dns.CanonicalName(t.Algorithm) {
+		return nil, dns.ErrKeyAlg
+	}
+	secret, err := base64.StdEncoding.DecodeString(key.Secret)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the algorithms the API offers are accepted, for every key:
+	// server-wide keys have no algorithm of their own and would otherwise
+	// take whatever a request names, HMAC-SHA1 included
+	var h hash.Hash
+	switch dns.CanonicalName(t.Algorithm) {
+	case dns.HmacSHA256:
+		h = hmac.New(sha256.New, secret)
+	case dns.HmacSHA512:
+		h = hmac.New(sha512.New, secret)
+	default:
+		return nil, dns.ErrKeyAlg
+	}
+	h.Write(msg)
+	return h.Sum(nil), nil
+}
+
+func (p tsigProvider) Verify(msg []byte, t *dns.TSIG) error {
+	expected, err := p.Generate(msg, t)
+	if err != nil {
+		return err
+	}
+	mac, err := hex.DecodeString(t.MAC)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(expected, mac) {
+		return dns.ErrSig
+	}
+	return nil
+}
+
+// signReply adds a TSIG record to m if the request r was signed with a
+// valid key, so the reply is signed with the same key when it is written.
+func signReply(w dns.ResponseWriter, r, m *dns.Msg) {
+	if tsig := r.IsTsig(); tsig != nil && w.TsigStatus() == nil {
+		m.SetTsig(tsig.Hdr.Name, tsig.Algorithm, tsig.Fudge, time.Now().Unix())
+	}
+}
+
+// tsigSize returns the space the TSIG record signing the reply to r takes,
+// or 0 if r is not signed.
+func tsigSize(r *dns.Msg) int {
+	tsig := r.IsTsig()
+	if tsig == nil {
+		return 0
+	}
+	reply := &dns.TSIG{
+		Hdr:       dns.RR_Header{Name: tsig.Hdr.Name, Rrtype: dns.TypeTSIG, Class: dns.ClassANY},
+		Algorithm: tsig.Algorithm,
+		MACSize:   sha512.Size,
+		MAC:       strings.Repeat("00", sha512.Size),
+		OrigId:    tsig.OrigId,
+		OtherLen:  6,
+		OtherData: strings.Repeat("00", 6),
+	}
+	return dns.Len(reply)
+}
@@ -0,0 +1,413 @@
+package dns
+
+import (
+	"dns-server/internal/models"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/miekg/dns"
+)
+
+// acceptMsg is the listeners' MsgAcceptFunc. miekg/dns rejects UPDATE
+// messages by default because their sections may hold any number of
+// records; we accept them and leave the other checks to the default.
+func acceptMsg(dh dns.Header) dns.MsgAcceptAction {
+	opcode := int(dh.Bits>>11) & 0xF
+	if opcode == dns.OpcodeUpdate && dh.Bits&(1<<15) == 0 {
+		// UPDATE needs exactly one zone (RFC 2136 section 3.1)
+		if dh.Qdcount != 1 {
+			return dns.MsgReject
+		}
+		return dns.MsgAccept
+	}
+	return dns.DefaultMsgAcceptFunc(dh)
+}
+
+// handleUpdate applies an RFC 2136 dynamic update to one of our zones. The
+// request must be signed with a TSIG key of that zone that may update it
+// and whose owner is the zone's owner. The prerequisites are checked and
+// the updates made in one database transaction holding the zone, so the
+// update is atomic, journaled and bumps the zone serial once.
+func (s *DNSServer) handleUpdate(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.Rcode = s.update(w, r)
+	signReply(w, r, m)
+	if err := w.WriteMsg(m); err != nil {
+		log.Printf("Failed to write DNS response: %v", err)
+	}
+}
+
+// update performs the update in r and returns the response code.
+func (s *DNSServer) update(w dns.ResponseWriter, r *dns.Msg) int {
+	q := r.Question[0]
+	client := remoteIP(w.RemoteAddr())
+
+	if q.Qtype != dns.TypeSOA || q.Qclass != dns.ClassINET {
+		return dns.RcodeFormatError
+	}
+	domain, owner, err := s.findZone(q.Name)
+	if err != nil {
+		log.Printf("DB query error for %s: %v", q.Name, err)
+		return dns.RcodeServerFailure
+	}
+	if domain == nil || owner != "@" || domain.Primary != nil {
+		log.Printf("UPDATE of %s from %s: not a zone we are primary for", q.Name, client)
+		return dns.RcodeNotAuth
+	}
+
+	tsig := r.IsTsig()
+	if tsig == nil {
+		log.Printf("UPDATE of %s from %s refused: not signed", q.Name, client)
+		return dns.RcodeRefused
+	}
+	if w.TsigStatus() != nil {
+		log.Printf("UPDATE of %s from %s: TSIG %s: %v", q.Name, client, tsig.Hdr.Name, w.TsigStatus())
+		return dns.RcodeNotAuth
+	}
+	key, err := s.tsigKey(tsig.Hdr.Name)
+	if err != nil || key.DomainID != domain.ID || key.UserID != domain.UserID || !key.CanUpdate {
+		log.Printf("UPDATE of %s from %s refused: key %s is not allowed to update the zone", q.Name, client, tsig.Hdr.Name)
+		return dns.RcodeRefused
+	}
+
+	keys, err := s.db.GetDNSSECKeysByDomain(domain.ID.String())
+	if err != nil {
+		log.Printf("Failed to load zone %s: %v", domain.DomainName, err)
+		return dns.RcodeServerFailure
+	}
+
+	rcode := dns.RcodeSuccess
+	err = s.db.UpdateZoneRecords(domain.ID.String(), func(records []models.Record) ([]models.RecordChange, error) {
+		e := newZoneEdit(domain, records, keys)
+		if rcode = e.z.checkPrerequisites(r.Answer); rcode != dns.RcodeSuccess {
+			return nil, nil
+		}
+		if rcode = e.z.prescanUpdates(r.Ns); rcode != dns.RcodeSuccess {
+			return nil, nil
+		}
+		for _, rr := range r.Ns {
+			if err := e.apply(rr); err != nil {
+				return nil, fmt.Errorf("%s: %v", rr, err)
+			}
+		}
+		return e.changes, nil
+	})
+	if err != nil {
+		log.Printf("UPDATE of %s from %s failed: %v", domain.DomainName, client, err)
+		return dns.RcodeServerFailure
+	}
+	if rcode != dns.RcodeSuccess {
+		return rcode
+	}
+
+	log.Printf("UPDATE of %s from %s with key %s: %d changes", domain.DomainName, client, key.Name, len(r.Ns))
+	return dns.RcodeSuccess
+}
+
+// checkPrerequisites evaluates the prerequisite section of an update (RFC
+// 2136 section 3.2) and returns the rcode of the first one that fails.
+func (z *zone) checkPrerequisites(prereqs []dns.RR) int {
+	// Value dependent prerequisites are compared per RRset once collected
+	expected := make(map[string]map[string]bool)
+
+	for _, rr := range prereqs {
+		h := rr.Header()
+		if h.Ttl != 0 {
+			return dns.RcodeFormatError
+		}
+		owner, ok := z.owner(h.Name)
+		if !ok {
+			return dns.RcodeNotZone
+		}
+
+		switch h.Class {
+		case dns.ClassANY:
+			if h.Rrtype == dns.TypeANY {
+				if !z.inUse(owner) {
+					return dns.RcodeNameError
+				}
+			} else if len(z.rrset(h.Name, owner, h.Rrtype)) == 0 {
+				return dns.RcodeNXRrset
+			}
+		case dns.ClassNONE:
+			if h.Rrtype == dns.TypeANY {
+				if z.inUse(owner) {
+					return dns.RcodeYXDomain
+				}
+			} else if len(z.rrset(h.Name, owner, h.Rrtype)) > 0 {
+				return dns.RcodeYXRrset
+			}
+		case dns.ClassINET:
+			set := owner + "/" + dns.TypeToString[h.Rrtype]
+			if expected[set] == nil {
+				expected[set] = make(map[string]bool)
+			}
+			expected[set][rrKey(rr)] = true
+		default:
+			return dns.RcodeFormatError
+		}
+	}
+
+	for set, want := range expected {
+		owner, rtype, _ := strings.Cut(set, "/")
+		have := make(map[string]bool)
+		for _, rr := range z.rrset(z.fqdn(owner), owner, dns.StringToType[rtype]) {
+			have[rrKey(rr)] = true
+		}
+		if len(have) != len(want) {
+			return dns.RcodeNXRrset
+		}
+		for key := range want {
+			if !have[key] {
+				return dns.RcodeNXRrset
+			}
+		}
+	}
+	return dns.RcodeSuccess
+}
+
+// prescanUpdates validates the update section before anything is changed
+// (RFC 2136 section 3.4.1).
+func (z *zone) prescanUpdates(updates []dns.RR) int {
+	for _, rr := range updates {
+		h := rr.Header()
+		if _, ok := z.owner(h.Name); !ok {
+			return dns.RcodeNotZone
+		}
+
+		switch h.Class {
+		case dns.ClassINET:
+			if metaType(h.Rrtype) {
+				return dns.RcodeFormatError
+			}
+			// Only what the records table can hold may be added
+			if h.Rrtype != dns.TypeSOA {
+				if _, err := rrToRecord(rr); err != nil {
+					return dns.RcodeRefused
+				}
+			}
+		case dns.ClassANY:
+			if h.Ttl != 0 || (h.Rrtype != dns.TypeANY && metaType(h.Rrtype)) {
+				return dns.RcodeFormatError
+			}
+		case dns.ClassNONE:
+			if h.Ttl != 0 || metaType(h.Rrtype) {
+				return dns.RcodeFormatError
+			}
+		default:
+			return dns.RcodeFormatError
+		}
+	}
+	return dns.RcodeSuccess
+}
+
+// zoneEdit collects the record changes of an update. The zone is built
+// once and the changes are made to its records as they are collected, so
+// each update RR sees the effect of the earlier ones without the zone, and
+// the NSEC chain of a signed one, being rebuilt for every RR. The zone
+// cache rebuilds it from the stored records once the update is committed.
+type zoneEdit struct {
+	changes []models.RecordChange
+	z       *zone
+}
+
+func newZoneEdit(domain *models.Domain, records []models.Record, keys []models.DNSSECKey) *zoneEdit {
+	return &zoneEdit{z: newZone(domain, records, keys)}
+}
+
+// pending returns the index of the change already collected for the
+// record with id, or -1.
+func (e *zoneEdit) pending(id uuid.UUID) int {
+	for i := range e.changes {
+		if e.changes[i].Record.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// replace sets the records of the zone at the owner of record to those
+// there other than record, followed by with.
+func (e *zoneEdit) replace(record models.Record, with ...models.Record) {
+	owner := strings.ToLower(record.Name)
+	var records []models.Record
+	for _, r := range e.z.records[owner] {
+		if r.ID != record.ID {
+			records = append(records, r)
+		}
+	}
+	e.z.records[owner] = append(records, with...)
+}
+
+func (e *zoneEdit) create(record models.Record) {
+	record.ID = uuid.New()
+	e.replace(record, record)
+	e.changes = append(e.changes, models.RecordChange{Action: "create", Record: record})
+}
+
+func (e *zoneEdit) update(record models.Record) {
+	e.replace(record, record)
+	if i := e.pending(record.ID); i >= 0 {
+		// A record created or updated earlier is stored as it ends up
+		e.changes[i].Record = record
+	} else {
+		e.changes = append(e.changes, models.RecordChange{Action: "update", Record: record})
+	}
+}
+
+func (e *zoneEdit) delete(record models.Record) {
+	e.replace(record)
+	i := e.pending(record.ID)
+	if i >= 0 {
+		created := e.changes[i].Action == "create"
+		e.changes = append(e.changes[:i], e.changes[i+1:]...)
+		if created {
+			// Never stored, so there is nothing to delete
+			return
+		}
+	}
+	e.changes = append(e.changes, models.RecordChange{Action: "delete", Record: record})
+}
+
+// apply makes the change described by one update RR (RFC 2136 section
+// 3.4.2). Changes the RFC says to silently ignore are skipped.
+func (e *zoneEdit) apply(rr dns.RR) error {
+	z := e.z
+	h := rr.Header()
+	owner, _ := z.owner(h.Name)
+
+	switch h.Class {
+	case dns.ClassINET:
+		// The server keeps the SOA itself
+		if h.Rrtype == dns.TypeSOA {
+			return nil
+		}
+		// A CNAME cannot share its name with other data
+		if h.Rrtype == dns.TypeCNAME && z.inUseExcept(owner, "CNAME") {
+			return nil
+		}
+		if h.Rrtype != dns.TypeCNAME && z.has(owner, "CNAME") {
+			return nil
+		}
+
+		record, err := rrToRecord(rr)
+		if err != nil {
+			return err
+		}
+		if existing := z.matching(owner, rr); len(existing) > 0 {
+			// Adding an existing record only refreshes its TTL
+			if existing[0].TTL == record.TTL {
+				return nil
+			}
+			existing[0].TTL = record.TTL
+			existing[0].UpdatedAt = time.Now()
+			e.update(existing[0])
+			return nil
+		}
+		if h.Rrtype == dns.TypeCNAME {
+			// A name holds a single alias, the new one replaces it
+			for _, old := range z.records[owner] {
+				e.delete(old)
+			}
+		}
+
+		record.DomainID = z.ID
+		record.Name = owner
+		record.CreatedAt = time.Now()
+		record.UpdatedAt = time.Now()
+		e.create(record)
+		return nil
+
+	case dns.ClassANY:
+		// Delete an RRset, or every RRset at the name
+		for _, record := range z.records[owner] {
+			if h.Rrtype != dns.TypeANY && dns.StringToType[record.Type] != h.Rrtype {
+				continue
+			}
+			if owner == "@" && (record.Type == "SOA" || record.Type == "NS") {
+				// The apex SOA and NS set are never deleted this way
+				continue
+			}
+			e.delete(record)
+		}
+		return nil
+
+	case dns.ClassNONE:
+		// Delete a single record
+		if h.Rrtype == dns.TypeSOA {
+			return nil
+		}
+		existing := z.matching(owner, rr)
+		if len(existing) == 0 {
+			return nil
+		}
+		if owner == "@" && h.Rrtype == dns.TypeNS && len(z.rrset(z.origin, "@", dns.TypeNS)) <= 1 {
+			// The last apex NS record stays
+			return nil
+		}
+		e.delete(existing[0])
+	}
+	return nil
+}
+
+// metaType reports whether rtype is a query or meta type that cannot be
+// stored in a zone.
+func metaType(rtype uint16) bool {
+	switch rtype {
+	case dns.TypeANY, dns.TypeAXFR, dns.TypeIXFR, dns.TypeMAILA, dns.TypeMAILB, dns.TypeTSIG, dns.TypeOPT, dns.TypeNone:
+		return true
+	}
+	return false
+}
+
+// owner returns the owner name of name relative to the zone, or false if
+// name is outside it.
+func (z *zone) owner(name string) (string, bool) {
+	if !dns.IsSubDomain(z.origin, dns.CanonicalName(name)) {
+		return "", false
+	}
+	return relativeName(normalizeName(name), normalizeName(z.origin)), true
+}
+
+// inUse reports whether owner holds any records (RFC 2136 "name is in
+// use"). Empty non-terminals do not count.
+func (z *zone) inUse(owner string) bool {
+	return z.inUseExcept(owner, "")
+}
+
+// inUseExcept reports whether owner holds records of a type other than
+// rtype.
+func (z *zone) inUseExcept(owner, rtype string) bool {
+	for _, record := range z.records[owner] {
+		if record.Type != rtype {
+			return true
+		}
+	}
+	return owner == "@"
+}
+
+// matching returns the stored records at owner with the same type and data
+// as rr, ignoring the TTL.
+func (z *zone) matching(owner string, rr dns.RR) []models.Record {
+	var records []models.Record
+	key := rrKey(rr)
+	for _, record := range z.records[owner] {
+		if dns.StringToType[record.Type] != rr.Header().Rrtype {
+			continue
+		}
+		stored, err := z.recordToRR(rr.Header().Name, record)
+		if err != nil {
+			continue
+		}
+		// Compare as the update's class, which is NONE for deletions
+		stored.Header().Class = rr.Header().Class
+		if rrKey(stored) == key {
+			records = append(records, record)
+		}
+	}
+	return records
+}
@@ -0,0 +1,488 @@
+package dns
+
+import (
+	"database/sql"
+	"dns-server/internal/models"
+	"dns-server/internal/services"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// zone is a snapshot of a hosted domain's records indexed by owner name.
+type zone struct {
+	*models.Domain
+
+	// origin is the fully qualified apex name, e.g. "example.com."
+	origin string
+	// records holds the stored records keyed by lowercased relative owner
+	// name, "@" for the apex.
+	records map[string][]models.Record
+	// backups holds the backup records the same way. They are only served
+	// in answers, when the rest of their RRset is unhealthy.
+	backups map[string][]models.Record
+	// names contains every owner name together with the empty non-terminals
+	// between it and the apex, so existence checks do not depend on a name
+	// holding records itself.
+	names map[string]bool
+	// keys is set when the zone is DNSSEC signed
+	keys *zoneKeys
+	// chain caches the NSEC owner names of a signed zone
+	chain []string
+
+	// views holds the variant of the zone served to the clients of each
+	// view that has records of its own
+	views map[string]*zone
+	// stored and dnssecKeys are what the zone and its views were built from
+	stored     []models.Record
+	dnssecKeys []models.DNSSECKey
+
+	// served are the records of this variant before the ones of a
+	// geographic target are chosen. The zone itself only serves the
+	// untargeted ones.
+	served []models.Record
+	// continents and countries are the geographic targets of served
+	continents, countries map[string]bool
+	// located caches the variants built for clients in one of the targets
+	locatedMu sync.Mutex
+	located   map[string]*zone
+
+	// tailored is set when answers from the zone depend on the client: it
+	// has view or geographic variants, or is one
+	tailored bool
+}
+
+// findZone returns the hosted domain that is authoritative for name and the
+// owner name relative to it ("@" for the apex). When several hosted domains
+// are suffixes of name (e.g. example.com and dev.example.com) the longest one
+// wins. A nil domain means none of the suffixes is hosted here.
+func (s *DNSServer) findZone(name string) (*models.Domain, string, error) {
+	name = normalizeName(name)
+
+	domain, err := s.db.GetLongestMatchingDomain(zoneCandidates(name))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	return domain, relativeName(name, domain.DomainName), nil
+}
+
+// hosted reports whether name is inside one of the zones we host. A name
+// that cannot be looked up counts as hosted, so the failure is reported by
+// the answer.
+func (s *DNSServer) hosted(name string) bool {
+	z, _, err := s.cachedZone(name)
+	return err != nil || z != nil
+}
+
+// loadZone reads all records and DNSSEC keys of domain into a zone. The
+// records of a secondary zone are the ones last transferred from its
+// primary.
+func (s *DNSServer) loadZone(domain *models.Domain) (*zone, error) {
+	var records []models.Record
+	var err error
+	if domain.Primary != nil {
+		records, err = s.db.GetSecondaryRecords(domain.ID.String())
+	} else {
+		records, err = s.db.GetRecordsByDomain(domain.ID.String())
+	}
+	if err != nil {
+		return nil, err
+	}
+	keys, err := s.db.GetDNSSECKeysByDomain(domain.ID.String())
+	if err != nil {
+		return nil, err
+	}
+	return newZone(domain, records, keys), nil
+}
+
+// newZone indexes the records and DNSSEC keys of domain. Records of a view
+// are left out of the zone itself, which is what transfers and clients
+// outside every view see, and go into a variant of the zone per view.
+func newZone(domain *models.Domain, records []models.Record, keys []models.DNSSECKey) *zone {
+	var zk *zoneKeys
+	if domain.DNSSEC {
+		zk = newZoneKeys(keys, dns.Fqdn(domain.DomainName))
+	}
+
+	var common []models.Record
+	if domain.Primary == nil && !hasApexSOA(records) {
+		common = append(common, defaultSOA(domain, records))
+	}
+	byView := make(map[string][]models.Record)
+	for _, record := range records {
+		if record.View == nil {
+			common = append(common, record)
+		} else {
+			byView[*record.View] = append(byView[*record.View], record)
+		}
+	}
+
+	z := servedZone(domain, common, zk)
+	z.stored, z.dnssecKeys = records, keys
+	for view, own := range byView {
+		v := servedZone(domain, overrideRecords(common, own), zk)
+		v.tailored = true
+		z.views[view] = v
+	}
+	z.tailored = z.tailored || len(z.views) > 0
+	return z
+}
+
+// Timers of the SOA served for zones whose owner did not store one.
+const (
+	defaultSOATTL     = 3600
+	defaultSOARefresh = 3600
+	defaultSOARetry   = 900
+	defaultSOAExpire  = 1209600
+	defaultSOAMinimum = 300
+)
+
+// hasApexSOA reports whether records hold an SOA for the apex.
+func hasApexSOA(records []models.Record) bool {
+	for _, record := range records {
+		if record.Type == "SOA" && record.Name == "@" && record.View == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultSOA returns the apex SOA of a zone whose owner did not store one.
+// The primary name server is the zone's first apex NS, and the serial is
+// filled in from the domain like that of a stored SOA.
+func defaultSOA(domain *models.Domain, records []models.Record) models.Record {
+	origin := dns.Fqdn(domain.DomainName)
+	mname := "ns1." + origin
+	for _, record := range records {
+		if record.Type == "NS" && record.Name == "@" && record.View == nil && record.Continent == nil && record.Country == nil {
+			mname = dns.Fqdn(record.Value)
+			break
+		}
+	}
+	return models.Record{
+		DomainID: domain.ID,
+		Type:     "SOA",
+		Name:     "@",
+		Value: fmt.Sprintf("%s hostmaster.%s %d %d %d %d %d", mname, origin, domain.Serial,
+			defaultSOARefresh, defaultSOARetry, defaultSOAExpire, defaultSOAMinimum),
+		TTL: defaultSOATTL,
+	}
+}
+
+// servedZone builds a zone serving records. Records with a geographic
+// target are only served by the variants of the zone for their target,
+// see forLocation.
+func servedZone(domain *models.Domain, records []models.Record, zk *zoneKeys) *zone {
+	var untargeted []models.Record
+	continents, countries := make(map[string]bool), make(map[string]bool)
+	for _, record := range records {
+		switch {
+		case record.Country != nil:
+			countries[*record.Country] = true
+		case record.Continent != nil:
+			continents[*record.Continent] = true
+		default:
+			untargeted = append(untargeted, record)
+		}
+	}
+
+	z := indexZone(domain, untargeted, zk)
+	z.served, z.continents, z.countries = records, continents, countries
+	z.tailored = len(continents) > 0 || len(countries) > 0
+	return z
+}
+
+// indexZone builds a zone serving exactly records.
+func indexZone(domain *models.Domain, records []models.Record, zk *zoneKeys) *zone {
+	z := &zone{
+		Domain:  domain,
+		origin:  dns.Fqdn(domain.DomainName),
+		records: make(map[string][]models.Record),
+		backups: make(map[string][]models.Record),
+		names:   map[string]bool{"@": true},
+		keys:    zk,
+		views:   make(map[string]*zone),
+		located: make(map[string]*zone),
+	}
+	for _, record := range records {
+		owner := strings.ToLower(record.Name)
+		if record.Backup {
+			z.backups[owner] = append(z.backups[owner], record)
+			continue
+		}
+		z.records[owner] = append(z.records[owner], record)
+		for name := owner; name != "@"; name = parentName(name) {
+			z.names[name] = true
+		}
+	}
+	if z.keys != nil {
+		// Zones are shared between concurrent questions once cached, so
+		// nothing may be filled in lazily
+		z.nsecChain()
+	}
+	return z
+}
+
+// overrideRecords returns common with the RRsets that own also has
+// replaced by those of own. A CNAME in own replaces everything at its
+// owner name, and any record in own replaces a CNAME there.
+func overrideRecords(common, own []models.Record) []models.Record {
+	types := make(map[string]map[string]bool)
+	for _, record := range own {
+		owner := strings.ToLower(record.Name)
+		if types[owner] == nil {
+			types[owner] = make(map[string]bool)
+		}
+		types[owner][record.Type] = true
+	}
+
+	records := append([]models.Record(nil), own...)
+	for _, record := range common {
+		present := types[strings.ToLower(record.Name)]
+		if present == nil || !(present[record.Type] || present["CNAME"] || record.Type == "CNAME") {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// forView returns the variant of the zone served to the clients of view.
+func (z *zone) forView(view string) *zone {
+	if v, ok := z.views[view]; ok {
+		return v
+	}
+	return z
+}
+
+// forLocation returns the variant of the zone served to clients at loc:
+// per owner name and type, the records targeting loc's country replace the
+// untargeted ones, or failing that the records targeting its continent do.
+// Variants are built on first use and only exist for the targets the zone
+// has, so there are few of them.
+func (z *zone) forLocation(loc services.Location) *zone {
+	var key string
+	if z.countries[loc.Country] {
+		key = loc.Country
+	}
+	key += "/"
+	if z.continents[loc.Continent] {
+		key += loc.Continent
+	}
+	if key == "/" {
+		return z
+	}
+
+	z.locatedMu.Lock()
+	defer z.locatedMu.Unlock()
+	if v, ok := z.located[key]; ok {
+		return v
+	}
+
+	var untargeted, country, continent []models.Record
+	for _, record := range z.served {
+		switch {
+		case record.Country != nil:
+			if *record.Country == loc.Country {
+				country = append(country, record)
+			}
+		case record.Continent != nil:
+			if *record.Continent == loc.Continent {
+				continent = append(continent, record)
+			}
+		default:
+			untargeted = append(untargeted, record)
+		}
+	}
+	records := overrideRecords(overrideRecords(untargeted, continent), country)
+
+	v := indexZone(z.Domain, records, z.keys)
+	v.tailored = true
+	z.located[key] = v
+	return v
+}
+
+// fqdn turns a relative owner name into a fully qualified one.
+func (z *zone) fqdn(owner string) string {
+	if owner == "@" {
+		return z.origin
+	}
+	return owner + "." + z.origin
+}
+
+// exists reports whether owner is a node of the zone, including empty
+// non-terminals such as "b" when only "a.b" holds records.
+func (z *zone) exists(owner string) bool {
+	return z.names[owner]
+}
+
+// delegation returns the topmost zone cut at or above owner, i.e. the
+// non-apex node closest to the apex that holds NS records. Everything at or
+// below a cut belongs to the child zone.
+func (z *zone) delegation(owner string) (string, bool) {
+	cut := ""
+	for name := owner; name != "@"; name = parentName(name) {
+		if z.has(name, "NS") {
+			cut = name
+		}
+	}
+	return cut, cut != ""
+}
+
+// has reports whether owner holds at least one record of type rtype.
+func (z *zone) has(owner, rtype string) bool {
+	for _, record := range z.records[owner] {
+		if record.Type == rtype {
+			return true
+		}
+	}
+	return false
+}
+
+// wildcard returns the wildcard node that synthesizes answers for owner,
+// which must not exist itself. Following RFC 4592 only the wildcard directly
+// below owner's closest encloser applies, so "*.preview" covers
+// a.preview and a.b.preview but is hidden below an existing b.preview.
+func (z *zone) wildcard(owner string) (string, bool) {
+	encloser := parentName(owner)
+	for !z.exists(encloser) {
+		encloser = parentName(encloser)
+	}
+
+	source := "*"
+	if encloser != "@" {
+		source += "." + encloser
+	}
+	return source, z.exists(source)
+}
+
+// rrset returns the records of the given type stored at owner, named qname.
+// dns.TypeANY selects every record at the name.
+func (z *zone) rrset(qname, owner string, qtype uint16) []dns.RR {
+	return z.recordsToRRs(qname, owner, qtype, z.records[owner])
+}
+
+// recordsToRRs is rrset for a chosen part of the records at owner.
+func (z *zone) recordsToRRs(qname, owner string, qtype uint16, records []models.Record) []dns.RR {
+	var rrs []dns.RR
+	for _, record := range records {
+		if qtype != dns.TypeANY && dns.StringToType[record.Type] != qtype {
+			continue
+		}
+		rr, err := z.recordToRR(qname, record)
+		if err != nil {
+			log.Printf("Skipping record %s in %s: %v", record.ID, z.DomainName, err)
+			continue
+		}
+		if soa, ok := rr.(*dns.SOA); ok {
+			// The serial is kept by the server, not the stored value
+			soa.Serial = uint32(z.Serial)
+		}
+		rrs = append(rrs, rr)
+	}
+
+	if owner == "@" && z.keys != nil {
+		for _, set := range [][]dns.RR{z.keys.dnskeys, z.keys.cds, z.keys.cdnskey} {
+			for _, key := range set {
+				if qtype != dns.TypeANY && key.Header().Rrtype != qtype {
+					continue
+				}
+				rr := dns.Copy(key)
+				rr.Header().Name = qname
+				rrs = append(rrs, rr)
+			}
+		}
+	}
+	return rrs
+}
+
+// recordToRR converts a record of the zone into a resource record named
+// qname. Transferred records hold their data in zone file format.
+func (z *zone) recordToRR(qname string, record models.Record) (dns.RR, error) {
+	if z.Primary != nil {
+		return dns.NewRR(fmt.Sprintf("%s %d IN %s %s", qname, record.TTL, record.Type, record.Value))
+	}
+	return recordToRR(qname, record)
+}
+
+// expired reports whether the zone is a secondary zone whose data is
+// missing or too old to serve, i.e. the primary could not be reached for
+// the SOA expire interval.
+func (z *zone) expired() bool {
+	return z.Primary != nil && (z.ExpiresAt == nil || time.Now().After(*z.ExpiresAt))
+}
+
+// soa returns the apex SOA record, or nil if the zone does not have one.
+func (z *zone) soa() dns.RR {
+	if rrs := z.rrset(z.origin, "@", dns.TypeSOA); len(rrs) > 0 {
+		return rrs[0]
+	}
+	return nil
+}
+
+// negativeSOA returns the SOA to place in the AUTHORITY section of an
+// NXDOMAIN or NODATA response. Per RFC 2308 its TTL is capped by the SOA
+// minimum field.
+func (z *zone) negativeSOA() dns.RR {
+	rr := z.soa()
+	if rr == nil {
+		return nil
+	}
+	soa := rr.(*dns.SOA)
+	if soa.Minttl < soa.Hdr.Ttl {
+		soa.Hdr.Ttl = soa.Minttl
+	}
+	return soa
+}
+
+// negative returns the AUTHORITY records every NXDOMAIN and NODATA response
+// carries: the negative SOA and, for DNSSEC clients of a signed zone, its
+// signatures.
+func (z *zone) negative(do bool) []dns.RR {
+	soa := z.negativeSOA()
+	if soa == nil {
+		return nil
+	}
+	return z.sign([]dns.RR{soa}, do, "")
+}
+
+// zoneCandidates lists every suffix of name, starting with name itself:
+// a.b.example.com yields a.b.example.com, b.example.com, example.com, com.
+func zoneCandidates(name string) []string {
+	labels := dns.SplitDomainName(name)
+	candidates := make([]string, 0, len(labels))
+	for i := range labels {
+		candidates = append(candidates, strings.Join(labels[i:], "."))
+	}
+	return candidates
+}
+
+// relativeName strips the zone origin from name, returning "@" for the apex.
+func relativeName(name, origin string) string {
+	if name == origin {
+		return "@"
+	}
+	return strings.TrimSuffix(name, "."+origin)
+}
+
+// parentName strips the leftmost label of a relative owner name.
+func parentName(owner string) string {
+	if i := strings.IndexByte(owner, '.'); i >= 0 {
+		return owner[i+1:]
+	}
+	return "@"
+}
+
+// normalizeName lowercases name and drops the trailing root dot so it can be
+// compared with domain names as they are stored in the database.
+func normalizeName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
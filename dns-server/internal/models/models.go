@@ -22,24 +22,146 @@ type Domain struct {
 	UserID     uuid.UUID `json:"user_id"`
 	DomainName string    `json:"domain_name"`
 	Verified   bool      `json:"verified"`
-	CreatedAt  time.Time `json:"created_at"`
-	UpdatedAt  time.Time `json:"updated_at"`
+	DNSSEC     bool      `json:"dnssec"`
+	Serial     int64     `json:"serial"` // SOA serial, bumped on every record change
+
+	// Secondary zones are transferred from Primary ("host" or "host:port")
+	// instead of being edited through the records API
+	Primary        *string    `json:"primary,omitempty"`
+	PrimaryTSIGKey *string    `json:"primary_tsig_key,omitempty"`
+	RefreshAt      *time.Time `json:"refresh_at,omitempty"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
 }
 
 type Record struct {
 	ID             uuid.UUID  `json:"id"`
 	DomainID       uuid.UUID  `json:"domain_id"`
-	DomainName     string     "json:domain_name,omitempty"
+	DomainName     string     `json:"domain_name,omitempty"`
 	Type           string     `json:"type"` // A, AAAA, CNAME, MX, etc.
 	Name           string     `json:"name"` // subdomain
 	Value          string     `json:"value"`
 	TTL            int        `json:"ttl"`
 	Priority       *int       `json:"priority,omitempty"` // only for MX/SRV
+	Weight         *int       `json:"weight,omitempty"`   // share of answers among the RRset's values
+	Backup         bool       `json:"backup"`             // only served while the rest of the RRset is unhealthy
 	ParentRecordID *uuid.UUID `json:"parent_record_id,omitempty"`
+	View           *string    `json:"view,omitempty"`      // only served to clients of this view
+	Continent      *string    `json:"continent,omitempty"` // only served to clients on this continent
+	Country        *string    `json:"country,omitempty"`   // only served to clients in this country
 	CreatedAt      time.Time  `json:"created_at"`
 	UpdatedAt      time.Time  `json:"updated_at"`
 }
 
+type DNSSECKey struct {
+	ID         uuid.UUID `json:"id"`
+	DomainID   uuid.UUID `json:"domain_id"`
+	KeyType    string    `json:"key_type"` // KSK or ZSK
+	Flags      int       `json:"flags"`    // 257 for KSK, 256 for ZSK
+	Algorithm  int       `json:"algorithm"`
+	KeyTag     int       `json:"key_tag"`
+	PublicKey  string    `json:"public_key"`
+	PrivateKey string    `json:"-"`
+	State      string    `json:"state"` // published, active, retired or removed
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+type DNSSECRollover struct {
+	ID           uuid.UUID  `json:"id"`
+	DomainID     uuid.UUID  `json:"domain_id"`
+	KeyType      string     `json:"key_type"` // KSK or ZSK
+	OldKeyID     uuid.UUID  `json:"old_key_id"`
+	NewKeyID     uuid.UUID  `json:"new_key_id"`
+	Status       string     `json:"status"` // publishing, retiring, waiting_ds, removing or completed
+	NextActionAt *time.Time `json:"next_action_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+type Secondary struct {
+	ID          uuid.UUID `json:"id"`
+	DomainID    uuid.UUID `json:"domain_id"`
+	Address     string    `json:"address"`                 // IP allowed to transfer the zone
+	TSIGKeyName *string   `json:"tsig_key_name,omitempty"` // key transfers must be signed with
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// RecordChange is one change of a batch made to a zone at once: a record
+// to create, update or delete.
+type RecordChange struct {
+	Action string // create, update, delete
+	Record Record
+}
+
+// ZoneChange is a zone journal entry: a record added to or deleted from a
+// zone by the change that produced Serial. An update is journaled as the
+// deletion of the old record and the addition of the new one. Every serial
+// also has a marker entry without a record: "serial", or "reset" when the
+// change cannot be sent as a difference.
+type ZoneChange struct {
+	ID        int64     `json:"id"`
+	DomainID  uuid.UUID `json:"domain_id"`
+	Serial    int64     `json:"serial"`
+	Action    string    `json:"action"` // add, delete, serial, reset
+	Type      string    `json:"type"`
+	Name      string    `json:"name"`
+	Value     string    `json:"value"`
+	TTL       int       `json:"ttl"`
+	Priority  *int      `json:"priority,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// HealthCheck probes the target of an A, AAAA or CNAME record. A target
+// turns unhealthy after Fall failed probes in a row and healthy again
+// after Rise successful ones; unhealthy targets are left out of answers.
+type HealthCheck struct {
+	ID             uuid.UUID  `json:"id"`
+	RecordID       uuid.UUID  `json:"record_id"`
+	Target         string     `json:"target"` // the record's value, i.e. what is probed
+	Type           string     `json:"type"`   // tcp, http, https
+	Port           int        `json:"port"`
+	Path           string     `json:"path,omitempty"`            // http(s) only
+	Host           *string    `json:"host,omitempty"`            // Host header and TLS server name
+	ExpectedStatus int        `json:"expected_status,omitempty"` // http(s) only
+	ExpectedBody   *string    `json:"expected_body,omitempty"`   // text the body must contain
+	Interval       int        `json:"interval"`                  // seconds
+	Timeout        int        `json:"timeout"`                   // seconds
+	Rise           int        `json:"rise"`
+	Fall           int        `json:"fall"`
+	Healthy        bool       `json:"healthy"`
+	LastCheckedAt  *time.Time `json:"last_checked_at,omitempty"`
+	LastError      *string    `json:"last_error,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// HealthCheckResult is the outcome of one probe of a health check.
+type HealthCheckResult struct {
+	ID        uuid.UUID `json:"id"`
+	CheckID   uuid.UUID `json:"check_id"`
+	Success   bool      `json:"success"`
+	Healthy   bool      `json:"healthy"` // state of the target after this probe
+	Error     *string   `json:"error,omitempty"`
+	Duration  int       `json:"duration_ms"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+type TSIGKey struct {
+	ID          uuid.UUID `json:"id"`
+	DomainID    uuid.UUID `json:"domain_id"`
+	UserID      uuid.UUID `json:"user_id"`
+	Name        string    `json:"name"`
+	Algorithm   string    `json:"algorithm"` // hmac-sha256, hmac-sha512
+	Secret      string    `json:"-"`         // base64, only returned when the key is created
+	CanTransfer bool      `json:"can_transfer"`
+	CanUpdate   bool      `json:"can_update"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
 type IPLog struct {
 	ID        uuid.UUID `json:"id"`
 	UserID    uuid.UUID `json:"user_id"`
@@ -4,8 +4,10 @@ import (
 	"dns-server/internal/controllers"
 	"dns-server/internal/middleware"
 	"encoding/json"
+	"expvar"
 	"log"
 	"net/http"
+	"strings"
 
 	"github.com/julienschmidt/httprouter"
 )
@@ -17,6 +19,13 @@ func (s *Server) RegisterRoutes() http.Handler {
 
 	r.GET("/", s.helloWorldHandler)
 
+	// DNS server counters, e.g. stale answers served while the database is down
+	r.GET("/debug/vars", s.metricsHandler)
+
+	// DNS-over-HTTPS (RFC 8484)
+	r.Handler(http.MethodGet, "/dns-query", s.dnsServer)
+	r.Handler(http.MethodPost, "/dns-query", s.dnsServer)
+
 	c := &controllers.Controllers{DB: s.db, SmtpService: *s.SmtpService}
 	mw := &middleware.Middleware{DB: s.db}
 
@@ -34,12 +43,39 @@ func (s *Server) RegisterRoutes() http.Handler {
 	r.GET("/domains/:id/records", mw.AuthMiddleware(c.GetDNSRecordsByDomain))
 	r.DELETE("/domains/:id", mw.AuthMiddleware(c.DeleteDomain))
 
+	// DNSSEC
+	r.GET("/domains/:id/dnssec", mw.AuthMiddleware(c.GetDNSSECStatus))
+	r.POST("/domains/:id/dnssec", mw.AuthMiddleware(c.EnableDNSSEC))
+	r.DELETE("/domains/:id/dnssec", mw.AuthMiddleware(c.DisableDNSSEC))
+	r.GET("/domains/:id/dnssec/keys", mw.AuthMiddleware(c.GetDNSSECKeys))
+	r.GET("/domains/:id/dnssec/ds", mw.AuthMiddleware(c.GetDNSSECDS))
+	r.GET("/domains/:id/dnssec/rollovers", mw.AuthMiddleware(c.GetDNSSECRollovers))
+	r.POST("/domains/:id/dnssec/rollovers", mw.AuthMiddleware(c.StartDNSSECRollover))
+	r.POST("/domains/:id/dnssec/rollovers/:rollover/ds-published", mw.AuthMiddleware(c.ConfirmDNSSECDS))
+
+	// Zone transfers
+	r.GET("/domains/:id/secondaries", mw.AuthMiddleware(c.GetSecondaries))
+	r.POST("/domains/:id/secondaries", mw.AuthMiddleware(c.AddSecondary))
+	r.DELETE("/domains/:id/secondaries/:secondary", mw.AuthMiddleware(c.DeleteSecondary))
+
+	// TSIG keys
+	r.GET("/domains/:id/tsig-keys", mw.AuthMiddleware(c.GetTSIGKeys))
+	r.POST("/domains/:id/tsig-keys", mw.AuthMiddleware(c.CreateTSIGKey))
+	r.GET("/domains/:id/tsig-keys/:key", mw.AuthMiddleware(c.GetTSIGKey))
+	r.PUT("/domains/:id/tsig-keys/:key", mw.AuthMiddleware(c.UpdateTSIGKey))
+	r.DELETE("/domains/:id/tsig-keys/:key", mw.AuthMiddleware(c.DeleteTSIGKey))
+
 	// DNS Records
 	r.POST("/records", mw.AuthMiddleware(c.RegisterDNSRecord))
 	r.GET("/records/:id", mw.AuthMiddleware(c.GetDNSRecordByID))
 	r.PUT("/records/:id", mw.AuthMiddleware(c.UpdateDNSRecord))
 	r.DELETE("/records/:id", mw.AuthMiddleware(c.DeleteDNSRecord))
 
+	// Record health checks
+	r.GET("/records/:id/health", mw.AuthMiddleware(c.GetRecordHealth))
+	r.PUT("/records/:id/health", mw.AuthMiddleware(c.PutRecordHealth))
+	r.DELETE("/records/:id/health", mw.AuthMiddleware(c.DeleteRecordHealth))
+
 	// auth
 	r.POST("/signup", c.SignUp)
 	r.POST("/login", c.Login)
@@ -75,3 +111,18 @@ func (s *Server) helloWorldHandler(w http.ResponseWriter, r *http.Request, _ htt
 
 	_, _ = w.Write(jsonResp)
 }
+
+// metricsHandler publishes the DNS server's expvar counters, the ones named
+// dns_*, in the format of expvar.Handler. The other variables, such as the
+// command line and memory statistics, are left out as the route is public.
+func (s *Server) metricsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	vars := make(map[string]json.RawMessage)
+	expvar.Do(func(kv expvar.KeyValue) {
+		if strings.HasPrefix(kv.Key, "dns_") {
+			vars[kv.Key] = json.RawMessage(kv.Value.String())
+		}
+	})
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(vars)
+}
@@ -19,14 +19,19 @@ type Server struct {
 	db          database.Service
 	HTTPServer  *http.Server
 	SmtpService *services.SmtpService
+
+	// dnsServer answers DNS-over-HTTPS queries and is started by main
+	dnsServer *dns.DNSServer
 }
 
 func NewServer() *Server {
 	port, _ := strconv.Atoi(os.Getenv("PORT"))
+	db := database.New()
 	NewServer := &Server{
 		port: port,
-		db:   database.New(),
+		db:   db,
 		SmtpService: services.InitSMTP(),
+		dnsServer: dns.NewDNSServer(db),
 	}
 
 	// Declare Server config
@@ -42,5 +47,5 @@ func NewServer() *Server {
 }
 
 func (s *Server) NewDNSServer() *dns.DNSServer {
-	return dns.NewDNSServer(s.db)
+	return s.dnsServer
 }
@@ -0,0 +1,159 @@
+package services
+
+import (
+	"crypto"
+	"dns-server/internal/models"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/miekg/dns"
+)
+
+// DNSKEYTTL is the TTL served for a zone's DNSKEY RRset.
+const DNSKEYTTL = 3600
+
+// Key rollover timings. A new ZSK is published for DNSSECPublishWait before
+// it signs, and the old one stays published until every signature it made
+// could have expired from caches, i.e. the zone's largest TTL plus
+// DNSSECPropagationMargin. An old KSK is removed DNSSECDSWait after the user
+// reports the new DS live at the parent.
+const (
+	DNSSECPublishWait       = 2 * DNSKEYTTL * time.Second
+	DNSSECPropagationMargin = time.Hour
+	DNSSECDSWait            = 48 * time.Hour
+)
+
+// DNSSEC key states, see the dnssec_keys table.
+const (
+	KeyPublished = "published"
+	KeyActive    = "active"
+	KeyRetired   = "retired"
+	KeyRemoved   = "removed"
+)
+
+// DNSSECAlgorithms maps the algorithm names accepted by the API to their
+// DNSSEC algorithm numbers and key sizes.
+var DNSSECAlgorithms = map[string]struct {
+	Number uint8
+	Bits   int
+}{
+	"ECDSAP256SHA256": {dns.ECDSAP256SHA256, 256},
+	"ED25519":         {dns.ED25519, 256},
+}
+
+// GenerateDNSSECKey creates a new key pair for zone in the given state.
+// keyType is "KSK" or "ZSK" and algorithm one of the DNSSECAlgorithms names.
+func GenerateDNSSECKey(domainID uuid.UUID, zone, keyType, algorithm, state string) (*models.DNSSECKey, error) {
+	alg, ok := DNSSECAlgorithms[algorithm]
+	if !ok {
+		return nil, fmt.Errorf("unsupported DNSSEC algorithm: %s", algorithm)
+	}
+
+	flags := uint16(dns.ZONE)
+	if keyType == "KSK" {
+		flags |= dns.SEP
+	}
+
+	key := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: dns.Fqdn(zone), Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: DNSKEYTTL},
+		Flags:     flags,
+		Protocol:  3,
+		Algorithm: alg.Number,
+	}
+	priv, err := key.Generate(alg.Bits)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.DNSSECKey{
+		DomainID:   domainID,
+		KeyType:    keyType,
+		Flags:      int(key.Flags),
+		Algorithm:  int(key.Algorithm),
+		KeyTag:     int(key.KeyTag()),
+		PublicKey:  key.PublicKey,
+		PrivateKey: key.PrivateKeyString(priv),
+		State:      state,
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	}, nil
+}
+
+// DNSKEY rebuilds the public DNSKEY record of a stored key for zone.
+func DNSKEY(key *models.DNSSECKey, zone string) *dns.DNSKEY {
+	return &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: dns.Fqdn(zone), Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: DNSKEYTTL},
+		Flags:     uint16(key.Flags),
+		Protocol:  3,
+		Algorithm: uint8(key.Algorithm),
+		PublicKey: key.PublicKey,
+	}
+}
+
+// DNSSECSigner parses the private half of a stored key.
+func DNSSECSigner(key *models.DNSSECKey, zone string) (crypto.Signer, error) {
+	priv, err := DNSKEY(key, zone).NewPrivateKey(key.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := priv.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("key %d cannot sign", key.KeyTag)
+	}
+	return signer, nil
+}
+
+// AlgorithmName returns the DNSSECAlgorithms name of an algorithm number.
+func AlgorithmName(number int) string {
+	for name, alg := range DNSSECAlgorithms {
+		if int(alg.Number) == number {
+			return name
+		}
+	}
+	return dns.AlgorithmToString[uint8(number)]
+}
+
+// ParentKeys returns the KSKs the parent zone should hold a DS for: the
+// active ones. A KSK being rolled out is retired and no longer listed.
+func ParentKeys(keys []models.DNSSECKey, zone string) []*dns.DNSKEY {
+	var dnskeys []*dns.DNSKEY
+	for i := range keys {
+		if keys[i].KeyType == "KSK" && keys[i].State == KeyActive {
+			dnskeys = append(dnskeys, DNSKEY(&keys[i], zone))
+		}
+	}
+	return dnskeys
+}
+
+// DSRecords returns the SHA-256 DS records to publish at the parent for zone.
+func DSRecords(keys []models.DNSSECKey, zone string) []string {
+	var records []string
+	for _, key := range ParentKeys(keys, zone) {
+		records = append(records, rrString(key.ToDS(dns.SHA256)))
+	}
+	return records
+}
+
+// CDSRecords returns the CDS records (RFC 7344) matching DSRecords.
+func CDSRecords(keys []models.DNSSECKey, zone string) []string {
+	var records []string
+	for _, key := range ParentKeys(keys, zone) {
+		records = append(records, rrString(key.ToDS(dns.SHA256).ToCDS()))
+	}
+	return records
+}
+
+// CDNSKEYRecords returns the CDNSKEY records (RFC 7344) for the parent keys.
+func CDNSKEYRecords(keys []models.DNSSECKey, zone string) []string {
+	var records []string
+	for _, key := range ParentKeys(keys, zone) {
+		records = append(records, rrString(key.ToCDNSKEY()))
+	}
+	return records
+}
+
+func rrString(rr dns.RR) string {
+	return strings.ReplaceAll(rr.String(), "\t", " ")
+}
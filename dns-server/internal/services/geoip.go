@@ -0,0 +1,88 @@
+package services
+
+import (
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Location is where an IP address is, as far as the GeoIP database knows.
+// Codes are upper case: continent codes such as "EU" and ISO 3166 country
+// codes such as "DE".
+type Location struct {
+	Continent string
+	Country   string
+	// CountryName and City are English names, for display
+	CountryName string
+	City        string
+}
+
+var (
+	geoIPOnce sync.Once
+	geoIP     *maxminddb.Reader
+)
+
+// openGeoIP opens the MaxMind format database (GeoLite2 or GeoIP2 Country
+// or City) named by GEOIP_DATABASE. Lookups find nothing when it is unset
+// or cannot be read.
+func openGeoIP() {
+	path := os.Getenv("GEOIP_DATABASE")
+	if path == "" {
+		return
+	}
+	db, err := maxminddb.Open(path)
+	if err != nil {
+		log.Printf("Failed to open GeoIP database %s: %v", path, err)
+		return
+	}
+	log.Printf("Using GeoIP database %s (%s, built %d)", path, db.Metadata.DatabaseType, db.Metadata.BuildEpoch)
+	geoIP = db
+}
+
+// LookupLocation returns the location of ip. ok is false when there is no
+// GeoIP database or ip is not in it.
+func LookupLocation(ip net.IP) (loc Location, ok bool) {
+	geoIPOnce.Do(openGeoIP)
+	if geoIP == nil || ip == nil {
+		return Location{}, false
+	}
+
+	var record struct {
+		Continent struct {
+			Code string `maxminddb:"code"`
+		} `maxminddb:"continent"`
+		Country struct {
+			ISOCode string            `maxminddb:"iso_code"`
+			Names   map[string]string `maxminddb:"names"`
+		} `maxminddb:"country"`
+		City struct {
+			Names map[string]string `maxminddb:"names"`
+		} `maxminddb:"city"`
+	}
+	if err := geoIP.Lookup(ip, &record); err != nil {
+		log.Printf("GeoIP lookup of %s failed: %v", ip, err)
+		return Location{}, false
+	}
+
+	loc = Location{
+		Continent:   strings.ToUpper(record.Continent.Code),
+		Country:     strings.ToUpper(record.Country.ISOCode),
+		CountryName: record.Country.Names["en"],
+		City:        record.City.Names["en"],
+	}
+	return loc, loc.Continent != "" || loc.Country != ""
+}
+
+// IsContinentCode reports whether code is one of the continent codes used
+// in GeoIP databases.
+func IsContinentCode(code string) bool {
+	switch code {
+	case "AF", "AN", "AS", "EU", "NA", "OC", "SA":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// TSIGAlgorithms maps the TSIG algorithms accepted by the API to the size of
+// the secrets generated for them, which matches the hash output size.
+var TSIGAlgorithms = map[string]int{
+	"hmac-sha256": 32,
+	"hmac-sha512": 64,
+}
+
+// GenerateTSIGSecret returns a random base64 secret for algorithm.
+func GenerateTSIGSecret(algorithm string) (string, error) {
+	size, ok := TSIGAlgorithms[algorithm]
+	if !ok {
+		return "", fmt.Errorf("unsupported TSIG algorithm: %s", algorithm)
+	}
+	secret := make([]byte, size)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(secret), nil
+}
+
+// ServerTSIGKeys reads the server-wide TSIG keys configured in DNS_TSIG_KEYS
+// as comma separated name:secret pairs, e.g. "transfer.example.com:c2VjcmV0",
+// keyed by fully qualified name. The algorithm is whatever the client signs
+// with.
+func ServerTSIGKeys() map[string]string {
+	secrets := make(map[string]string)
+	for _, pair := range strings.Split(os.Getenv("DNS_TSIG_KEYS"), ",") {
+		name, secret, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok || name == "" || secret == "" {
+			continue
+		}
+		secrets[dns.CanonicalName(name)] = secret
+	}
+	return secrets
+}
+
+// IsServerTSIGKey reports whether name is one of the server-wide TSIG keys.
+func IsServerTSIGKey(name string) bool {
+	_, ok := ServerTSIGKeys()[dns.CanonicalName(name)]
+	return ok
+}
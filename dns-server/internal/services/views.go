@@ -0,0 +1,84 @@
+package services
+
+import (
+	"log"
+	"net"
+	"os"
+	"strings"
+)
+
+// View is a named group of client networks that is served the records
+// marked with its name in place of the ones without a view (split horizon).
+type View struct {
+	Name     string
+	Networks []*net.IPNet
+}
+
+// DNSViews reads the views configured in DNS_VIEWS, separated by
+// semicolons, e.g. "internal=10.0.0.0/8,192.168.0.0/16;lab=172.16.0.0/12".
+// A client belongs to the first view listing its network.
+func DNSViews() []View {
+	var views []View
+	for _, entry := range strings.Split(os.Getenv("DNS_VIEWS"), ";") {
+		name, networks, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || name == "" {
+			continue
+		}
+		views = append(views, View{Name: strings.TrimSpace(name), Networks: ParseNetworks(networks)})
+	}
+	return views
+}
+
+// IsDNSView reports whether name is one of the configured views.
+func IsDNSView(name string) bool {
+	for _, view := range DNSViews() {
+		if view.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseNetworks reads a comma separated list of addresses and CIDR
+// networks, e.g. "192.0.2.53,2001:db8::/32".
+func ParseNetworks(v string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, entry := range strings.Split(v, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			log.Printf("Ignoring invalid network %q: %v", entry, err)
+			continue
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// ContainsIP reports whether ip is inside one of networks.
+func ContainsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsPublicIP reports whether ip may be reached on behalf of users: it is
+// not a loopback, private, link-local, multicast or unspecified address,
+// which would let them probe this host and the networks behind it.
+func IsPublicIP(ip net.IP) bool {
+	return ip != nil && !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsUnspecified() &&
+		!ip.IsLinkLocalUnicast() && !ip.IsMulticast()
+}